@@ -0,0 +1,295 @@
+// Package adapter converts between this server's handlers and the standard
+// library net/http ones, so existing net/http code can be mounted on a
+// router.Router and a router.Router can be served by a net/http server.
+package adapter
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	stdhttp "net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/handler"
+	"github.com/codecrafters-io/http-server-starter-go/http"
+	"github.com/codecrafters-io/http-server-starter-go/middleware"
+	"github.com/codecrafters-io/http-server-starter-go/router"
+)
+
+// FromNetHTTP wraps a net/http handler so it can be registered on a router.
+// Headers, body and status are translated as is, and calls to Flush through
+// http.ResponseController or http.Flusher stream the response to the client.
+func FromNetHTTP(h stdhttp.Handler) handler.Handler {
+	return handler.HandlerFunc(func(req *http.Request, resp *http.Response) {
+		stdReq, err := toStdRequest(req)
+		if err != nil {
+			fmt.Println("Error converting request for net/http handler ", err)
+			resp.StatusCode = stdhttp.StatusBadRequest
+			return
+		}
+
+		w := newResponseWriter(resp)
+		h.ServeHTTP(w, stdReq)
+		w.finish()
+	})
+}
+
+// MiddlewareFromNetHTTP wraps a net/http middleware so it can be used with
+// Router.Use, groups or single routes. The next handler's response goes
+// through the ResponseWriter the middleware passes down, so wrappers
+// recording the status or rewriting the body see it.
+func MiddlewareFromNetHTTP(mw func(stdhttp.Handler) stdhttp.Handler) middleware.Middleware {
+	return func(next handler.Handler) handler.Handler {
+		inner := mw(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+			req := r.Context().Value(requestKey{}).(*http.Request)
+			if err := syncRequest(req, r); err != nil {
+				stdhttp.Error(w, "malformed request target", stdhttp.StatusBadRequest)
+				return
+			}
+
+			resp := http.NewResponseWithSink(req, &stdSink{w: w})
+			next.Handle(req, resp)
+			if err := resp.SendToClient(req); errors.Is(err, http.ErrAbortedResponse) {
+				panic(stdhttp.ErrAbortHandler)
+			}
+		}))
+
+		return handler.HandlerFunc(func(req *http.Request, resp *http.Response) {
+			stdReq, err := toStdRequest(req)
+			if err != nil {
+				fmt.Println("Error converting request for net/http middleware ", err)
+				resp.StatusCode = stdhttp.StatusBadRequest
+				return
+			}
+
+			// The original request is handed back to next through the context
+			stdReq = stdReq.WithContext(context.WithValue(stdReq.Context(), requestKey{}, req))
+			w := newResponseWriter(resp)
+			inner.ServeHTTP(w, stdReq)
+			w.finish()
+		})
+	}
+}
+
+type requestKey struct{}
+
+// ToNetHTTP exposes h as a net/http handler.
+func ToNetHTTP(h handler.Handler) stdhttp.Handler {
+	return stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		req, err := fromStdRequest(r)
+		if err != nil {
+			stdhttp.Error(w, "failed to read request body", stdhttp.StatusBadRequest)
+			return
+		}
+
+		resp := http.NewResponseWithSink(req, &stdSink{w: w})
+		h.Handle(req, resp)
+		// net/http closes the connection on ErrAbortHandler
+		if err := resp.SendToClient(req); errors.Is(err, http.ErrAbortedResponse) {
+			panic(stdhttp.ErrAbortHandler)
+		}
+	})
+}
+
+// RouterToNetHTTP exposes r as a net/http handler.
+func RouterToNetHTTP(r *router.Router) stdhttp.Handler {
+	return ToNetHTTP(handler.HandlerFunc(r.ServeHTTP))
+}
+
+// toStdRequest builds a net/http server request from req.
+func toStdRequest(req *http.Request) (*stdhttp.Request, error) {
+	target, err := url.ParseRequestURI(req.RequestURI())
+	if err != nil {
+		return nil, err
+	}
+
+	stdReq := &stdhttp.Request{
+		Method:     req.Method,
+		URL:        target,
+		RequestURI: req.RequestURI(),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     stdhttp.Header(req.Headers.Clone()),
+		Body:       io.NopCloser(&bodyReader{req: req}),
+		Host:       req.Headers.Get("Host"),
+	}
+	stdReq.ContentLength = contentLength(req)
+	stdReq = stdReq.WithContext(req.Context())
+
+	if req.Trailers != nil {
+		stdReq.Trailer = stdhttp.Header(req.Trailers.Clone())
+	}
+
+	if req.Connection != nil {
+		stdReq.RemoteAddr = req.Connection.RemoteAddr().String()
+		if tlsConn, ok := req.Connection.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			stdReq.TLS = &state
+		}
+	}
+
+	return stdReq, nil
+}
+
+// bodyReader reads the request body on first use, so a body announced with
+// "Expect: 100-continue" is only requested if the net/http handler reads it.
+type bodyReader struct {
+	req    *http.Request
+	reader io.Reader
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.reader == nil {
+		body, err := b.req.ReadBody()
+		if err != nil {
+			return 0, err
+		}
+		b.reader = strings.NewReader(body)
+	}
+	return b.reader.Read(p)
+}
+
+// contentLength returns the length of the request body, or -1 if it is not
+// known yet.
+func contentLength(req *http.Request) int64 {
+	if req.BodyConsumed() {
+		return int64(len(req.Body))
+	}
+	length, err := strconv.ParseInt(req.Headers.Get("Content-Length"), 10, 64)
+	if err != nil || req.Headers.Has("Transfer-Encoding") {
+		return -1
+	}
+	return length
+}
+
+// fromStdRequest builds a request for this server's handlers from a net/http one.
+func fromStdRequest(r *stdhttp.Request) (*http.Request, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method:  r.Method,
+		Headers: http.Header(r.Header.Clone()),
+		Body:    string(body),
+	}
+	if err := req.SetTarget(r.URL.RequestURI()); err != nil {
+		return nil, err
+	}
+	req.SetContext(r.Context())
+	req.ClientCert = http.ClientCertFromState(r.TLS)
+	if r.Host != "" {
+		req.Headers.Set("Host", r.Host)
+	}
+	if r.ContentLength > 0 {
+		req.Headers.Set("Content-Length", strconv.FormatInt(r.ContentLength, 10))
+	}
+
+	return req, nil
+}
+
+// syncRequest copies the changes a net/http middleware made to its request,
+// context values included, back to req before calling the next handler.
+func syncRequest(req *http.Request, r *stdhttp.Request) error {
+	req.Method = r.Method
+	if err := req.SetTarget(r.URL.RequestURI()); err != nil {
+		return err
+	}
+
+	req.Headers = http.Header(r.Header.Clone())
+	req.SetContext(r.Context())
+	return nil
+}
+
+// responseWriter implements net/http ResponseWriter on top of a Response.
+type responseWriter struct {
+	resp        *http.Response
+	header      stdhttp.Header
+	wroteHeader bool
+}
+
+func newResponseWriter(resp *http.Response) *responseWriter {
+	return &responseWriter{resp: resp, header: stdhttp.Header{}}
+}
+
+func (w *responseWriter) Header() stdhttp.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	for k, values := range w.header {
+		w.resp.Headers[k] = values
+	}
+	w.resp.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.header.Get("Content-Type") == "" && len(p) > 0 {
+			w.header.Set("Content-Type", stdhttp.DetectContentType(p))
+		}
+		w.WriteHeader(stdhttp.StatusOK)
+	}
+	return w.resp.Write(p)
+}
+
+// finish copies the headers of a handler that returned without writing anything.
+func (w *responseWriter) finish() {
+	if !w.wroteHeader {
+		w.WriteHeader(stdhttp.StatusOK)
+	}
+}
+
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(stdhttp.StatusOK)
+	}
+	if err := w.resp.Flush(); err != nil {
+		fmt.Println("Error flushing response ", err)
+	}
+}
+
+// stdSink sends a Response through a net/http ResponseWriter, which takes
+// care of the framing itself.
+type stdSink struct {
+	w stdhttp.ResponseWriter
+}
+
+func (s *stdSink) WriteHeader(statusCode int, statusMessage string, headers http.Header) error {
+	for k, values := range headers {
+		switch k {
+		case "Transfer-Encoding", "Connection":
+			continue // Hop-by-hop, managed by net/http
+		}
+		s.w.Header()[k] = values
+	}
+	s.w.WriteHeader(statusCode)
+	return nil
+}
+
+func (s *stdSink) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *stdSink) Flush() error {
+	err := stdhttp.NewResponseController(s.w).Flush()
+	if errors.Is(err, stdhttp.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+func (s *stdSink) Close() error {
+	return nil
+}
@@ -0,0 +1,229 @@
+package adapter
+
+import (
+	"bytes"
+	"io"
+	"net"
+	stdhttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/http-server-starter-go/handler"
+	"github.com/codecrafters-io/http-server-starter-go/http"
+	"github.com/codecrafters-io/http-server-starter-go/router"
+)
+
+// recordingConn captures everything written to it.
+type recordingConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) { return c.written.Write(b) }
+
+func (c *recordingConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}
+}
+
+func TestFromNetHTTP_TranslatesRequestAndResponse(t *testing.T) {
+	std := stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != "POST" || r.URL.Path != "/api/items" || r.URL.Query().Get("page") != "2" {
+			t.Errorf("Unexpected request line %s %s", r.Method, r.URL)
+		}
+		if r.Header.Get("X-Token") != "secret" {
+			t.Errorf("Expected X-Token header 'secret', got '%s'", r.Header.Get("X-Token"))
+		}
+		if r.RemoteAddr != "127.0.0.1:5000" {
+			t.Errorf("Expected RemoteAddr '127.0.0.1:5000', got '%s'", r.RemoteAddr)
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Handler", "std")
+		w.WriteHeader(stdhttp.StatusTeapot)
+		w.Write([]byte("got " + string(body)))
+	})
+
+	req := &http.Request{
+		Method:     "POST",
+		Path:       "/api/items",
+		RawQuery:   "page=2",
+		Headers:    http.Header{"X-Token": {"secret"}, "Host": {"example.com"}},
+		Body:       "payload",
+		Connection: &recordingConn{},
+	}
+	resp := http.NewResponse(req)
+
+	FromNetHTTP(std).Handle(req, resp)
+
+	if resp.StatusCode != stdhttp.StatusTeapot {
+		t.Errorf("Expected status 418, got %d", resp.StatusCode)
+	}
+	if resp.Headers.Get("X-Handler") != "std" {
+		t.Errorf("Expected X-Handler header 'std', got '%s'", resp.Headers.Get("X-Handler"))
+	}
+	if resp.BufferedBody() != "got payload" {
+		t.Errorf("Expected body 'got payload', got '%s'", resp.BufferedBody())
+	}
+	if resp.Committed() {
+		t.Error("Expected a response without Flush to stay buffered")
+	}
+}
+
+func TestFromNetHTTP_HeadersWithoutWrite(t *testing.T) {
+	std := stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		w.Header().Set("Location", "/elsewhere")
+	})
+
+	req := &http.Request{Method: "GET", Path: "/", Headers: http.Header{}}
+	resp := http.NewResponse(req)
+
+	FromNetHTTP(std).Handle(req, resp)
+
+	if resp.Headers.Get("Location") != "/elsewhere" {
+		t.Errorf("Expected Location header to be kept, got '%s'", resp.Headers.Get("Location"))
+	}
+	if resp.StatusCode != stdhttp.StatusOK {
+		t.Errorf("Expected implicit status 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestFromNetHTTP_Streaming(t *testing.T) {
+	std := stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		w.Write([]byte("part one"))
+		stdhttp.NewResponseController(w).Flush()
+		w.Write([]byte("part two"))
+	})
+
+	conn := &recordingConn{}
+	req := &http.Request{Method: "GET", Path: "/stream", Headers: http.Header{}, Connection: conn}
+	resp := http.NewResponse(req)
+
+	FromNetHTTP(std).Handle(req, resp)
+	if !resp.Committed() {
+		t.Fatal("Expected Flush to commit the response")
+	}
+	resp.SendToClient(req)
+
+	out := conn.written.String()
+	if !strings.Contains(out, "Transfer-Encoding:chunked\r\n") {
+		t.Errorf("Expected chunked response, got %q", out)
+	}
+	if !strings.HasSuffix(out, "8\r\npart one\r\n8\r\npart two\r\n0\r\n\r\n") {
+		t.Errorf("Unexpected streamed body %q", out)
+	}
+}
+
+func TestMiddlewareFromNetHTTP(t *testing.T) {
+	var recordedStatus int
+	stdMiddleware := func(next stdhttp.Handler) stdhttp.Handler {
+		return stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+			w.Header().Set("X-Middleware", "std")
+			r.Header.Set("X-Request-Id", "abc")
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+			recordedStatus = recorder.status
+		})
+	}
+
+	r := router.NewRouter()
+	r.Use(MiddlewareFromNetHTTP(stdMiddleware))
+	r.Get("/items/{id}", handler.HandlerFunc(func(req *http.Request, resp *http.Response) {
+		resp.StatusCode = 201
+		resp.Body = req.PathParam("id") + " " + req.Headers.Get("X-Request-Id")
+	}))
+
+	req := &http.Request{Method: "GET", Path: "/items/7", Headers: http.Header{}}
+	resp := http.NewResponse(req)
+	r.ServeHTTP(req, resp)
+
+	if recordedStatus != 201 {
+		t.Errorf("Expected middleware to record status 201, got %d", recordedStatus)
+	}
+	if resp.StatusCode != 201 {
+		t.Errorf("Expected status 201, got %d", resp.StatusCode)
+	}
+	if resp.Headers.Get("X-Middleware") != "std" {
+		t.Errorf("Expected X-Middleware header 'std', got '%s'", resp.Headers.Get("X-Middleware"))
+	}
+	if resp.BufferedBody() != "7 abc" {
+		t.Errorf("Expected body '7 abc', got '%s'", resp.BufferedBody())
+	}
+}
+
+type statusRecorder struct {
+	stdhttp.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func TestRouterToNetHTTP(t *testing.T) {
+	r := router.NewRouter()
+	r.Get("/echo/{message}", handler.HandlerFunc(func(req *http.Request, resp *http.Response) {
+		resp.StatusCode = 200
+		resp.Headers.Set("Content-Type", "text/plain")
+		resp.Body = req.PathParam("message")
+	}))
+	r.Post("/upload", handler.HandlerFunc(func(req *http.Request, resp *http.Response) {
+		resp.StatusCode = 201
+		resp.Body = strings.ToUpper(req.Body)
+	}))
+	r.Get("/stream", handler.HandlerFunc(func(req *http.Request, resp *http.Response) {
+		resp.StatusCode = 200
+		resp.Flush()
+		for _, part := range []string{"a", "b", "c"} {
+			resp.Write([]byte(part))
+			resp.Flush()
+		}
+	}))
+
+	server := httptest.NewServer(RouterToNetHTTP(r))
+	defer server.Close()
+
+	res, err := stdhttp.Get(server.URL + "/echo/hello")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 200 || string(body) != "hello" || res.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("Unexpected echo response %d '%s' %v", res.StatusCode, body, res.Header)
+	}
+
+	res, err = stdhttp.Post(server.URL+"/upload", "text/plain", strings.NewReader("data"))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 201 || string(body) != "DATA" {
+		t.Errorf("Unexpected upload response %d '%s'", res.StatusCode, body)
+	}
+
+	res, err = stdhttp.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "abc" {
+		t.Errorf("Expected streamed body 'abc', got '%s'", body)
+	}
+	if len(res.TransferEncoding) == 0 || res.TransferEncoding[0] != "chunked" {
+		t.Errorf("Expected chunked streamed response, got %v", res.TransferEncoding)
+	}
+
+	res, err = stdhttp.Post(server.URL+"/echo/hello", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 405 || res.Header.Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("Expected 405 with Allow header, got %d '%s'", res.StatusCode, res.Header.Get("Allow"))
+	}
+}
@@ -3,7 +3,6 @@ package handler
 import (
 	"net/http"
 	"strconv"
-	"strings"
 
 	httpPkg "github.com/codecrafters-io/http-server-starter-go/http"
 )
@@ -15,9 +14,9 @@ func NewEchoHandler() *EchoHandler {
 }
 
 func (eh *EchoHandler) Handle(req *httpPkg.Request, res *httpPkg.Response) {
-	body := strings.TrimPrefix(req.Path, "/echo/")
+	body := req.PathParam("message")
 	res.StatusCode = http.StatusOK
-	res.Headers["Content-Type"] = "text/plain"
-	res.Headers["Content-Length"] = strconv.Itoa(len(body))
+	res.Headers.Set("Content-Type", "text/plain")
+	res.Headers.Set("Content-Length", strconv.Itoa(len(body)))
 	res.Body = body
 }
@@ -1,13 +1,16 @@
 package handler
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
+	"github.com/codecrafters-io/http-server-starter-go/config"
 	httpPkg "github.com/codecrafters-io/http-server-starter-go/http"
 )
 
@@ -19,33 +22,29 @@ func NewFileHandler(fileDir string) *FileHandler {
 	return &FileHandler{fileDir: fileDir}
 }
 
-func (fh *FileHandler) Handle(req *httpPkg.Request, res *httpPkg.Response) {
-	if req.Method == "GET" {
-		fh.handleRead(req, res)
-	} else if req.Method == "POST" {
-		fh.handleUpload(req, res)
-	} else {
-		res.StatusCode = http.StatusMethodNotAllowed
-	}
-}
-
-func (fh *FileHandler) handleUpload(request *httpPkg.Request, response *httpPkg.Response) {
-	contentLength, err := strconv.Atoi(request.Headers["Content-Length"])
-	if err != nil {
+// Upload stores the request body as the file named by the "name" path parameter.
+func (fh *FileHandler) Upload(request *httpPkg.Request, response *httpPkg.Response) {
+	// Invalid uploads are rejected before reading the body, so a client
+	// waiting for 100 Continue does not send it
+	if !request.Headers.Has("Content-Length") && !request.Headers.Has("Transfer-Encoding") {
 		response.StatusCode = http.StatusBadRequest
 		return
 	}
 
-	if len(request.Body) < contentLength {
+	filePath, ok := fh.filePath(request)
+	if !ok {
 		response.StatusCode = http.StatusBadRequest
 		return
 	}
 
-	fileName := filepath.Base(request.Path)
-	filePath := filepath.Join(fh.fileDir, fileName)
-	fileData := request.Body[:contentLength]
+	body, err := request.ReadBody()
+	if err != nil {
+		fmt.Printf("Error reading upload %s: %v\n", filePath, err)
+		response.StatusCode = httpPkg.ErrorStatus(err)
+		return
+	}
 
-	err = os.WriteFile(filePath, []byte(fileData), 0666)
+	err = os.WriteFile(filePath, []byte(body), 0666)
 	if err != nil {
 		fmt.Printf("Error writing file %s: %v\n", filePath, err)
 		response.StatusCode = http.StatusInternalServerError
@@ -55,31 +54,88 @@ func (fh *FileHandler) handleUpload(request *httpPkg.Request, response *httpPkg.
 	response.StatusCode = http.StatusCreated
 }
 
-func (fh *FileHandler) handleRead(request *httpPkg.Request, response *httpPkg.Response) {
-	pathsplit := strings.Split(request.Path, "/")
-	if len(pathsplit) < 3 || pathsplit[2] == "" {
+// Read sends the content of the file named by the "name" path parameter.
+func (fh *FileHandler) Read(request *httpPkg.Request, response *httpPkg.Response) {
+	filePath, ok := fh.filePath(request)
+	if !ok {
 		response.StatusCode = http.StatusBadRequest
 		return
 	}
 
-	filename := pathsplit[2]
-	// Validate filename to prevent directory traversal
-	if strings.Contains(filename, "..") || strings.Contains(filename, "/") {
-		response.StatusCode = http.StatusBadRequest
+	file, err := os.Open(filePath)
+	if err != nil {
+		fmt.Println("Error reading file ", filePath, err)
+		response.StatusCode = http.StatusNotFound
 		return
 	}
+	defer file.Close()
 
-	filePath := filepath.Join(fh.fileDir, filename)
-
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		fmt.Println("Error reading file ", filePath, err)
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
 		response.StatusCode = http.StatusNotFound
 		return
 	}
 
 	response.StatusCode = http.StatusOK
-	response.Headers["Content-Type"] = "application/octet-stream"
-	response.Headers["Content-Length"] = strconv.Itoa(len(content))
-	response.Body = string(content)
+	response.Headers.Set("Content-Type", "application/octet-stream")
+	response.Headers.Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+
+	// Small files stay buffered so middlewares can still rewrite them,
+	// larger ones are streamed to the client instead of being held in memory
+	if info.Size() > config.BufferSize {
+		if err := response.Flush(); err != nil {
+			return
+		}
+	}
+
+	// Stop reading the file once the client is gone or the request expired
+	reader := &contextReader{ctx: request.Context(), reader: file}
+	if _, err := io.Copy(response, reader); err != nil {
+		fmt.Println("Error sending file ", filePath, err)
+
+		// A failed write is returned again when the response is sent, which
+		// closes the connection. After a failed read the client must not take
+		// a partial file for a complete one.
+		if reader.err == nil {
+			return
+		}
+		if response.Committed() {
+			response.Abort()
+			return
+		}
+		response.StatusCode = http.StatusInternalServerError
+		response.Headers.Del("Content-Type")
+		response.Headers.Del("Content-Length")
+		response.SetBody("")
+	}
+}
+
+// contextReader fails with the cause of ctx once ctx is done.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+	err    error // read error other than io.EOF, if any
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if r.ctx.Err() != nil {
+		r.err = context.Cause(r.ctx)
+		return 0, r.err
+	}
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// filePath resolves the "name" path parameter inside the served directory.
+func (fh *FileHandler) filePath(request *httpPkg.Request) (string, bool) {
+	filename := request.PathParam("name")
+	// Validate the decoded filename to prevent directory traversal
+	if filename == "" || filename == "." || strings.Contains(filename, "..") || strings.ContainsAny(filename, "/\\\x00") {
+		return "", false
+	}
+
+	return filepath.Join(fh.fileDir, filename), true
 }
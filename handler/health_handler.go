@@ -14,11 +14,14 @@ type HealthHandler struct {
 }
 
 type HealthResponse struct {
-	Status            string `json:"status"`
-	Timestamp         string `json:"timestamp"`
-	Uptime            string `json:"uptime"`
-	ActiveConnections int    `json:"active_connections"`
-	TotalRequests     int    `json:"total_requests"`
+	Status            string            `json:"status"`
+	Timestamp         string            `json:"timestamp"`
+	Uptime            string            `json:"uptime"`
+	ActiveConnections int               `json:"active_connections"`
+	TotalRequests     int               `json:"total_requests"`
+	Timeouts          TimeoutCounts     `json:"timeouts"`
+	Panics            int               `json:"panics"`
+	Certificates      []CertificateInfo `json:"certificates,omitempty"`
 }
 
 func NewHealthHandler(metrics ServerMetrics) *HealthHandler {
@@ -36,6 +39,9 @@ func (eh *HealthHandler) Handle(req *http.Request, resp *http.Response) {
 		Uptime:            time.Since(eh.metrics.ServerStartTime()).String(),
 		ActiveConnections: eh.metrics.GetOpenConnections(),
 		TotalRequests:     eh.metrics.GetTotalRequests(),
+		Timeouts:          eh.metrics.GetTimeouts(),
+		Panics:            eh.metrics.GetPanics(),
+		Certificates:      eh.metrics.GetCertificates(),
 	}
 
 	jsonBody, err := json.Marshal(healthData)
@@ -44,6 +50,6 @@ func (eh *HealthHandler) Handle(req *http.Request, resp *http.Response) {
 	}
 
 	resp.Body = string(jsonBody)
-	resp.Headers["Content-Type"] = "application/json"
-	resp.Headers["Content-Length"] = strconv.Itoa(len(resp.Body))
+	resp.Headers.Set("Content-Type", "application/json")
+	resp.Headers.Set("Content-Length", strconv.Itoa(len(resp.Body)))
 }
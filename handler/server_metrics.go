@@ -6,4 +6,22 @@ type ServerMetrics interface {
 	ServerStartTime() time.Time
 	GetOpenConnections() int
 	GetTotalRequests() int
+	GetTimeouts() TimeoutCounts
+	GetPanics() int
+	GetCertificates() []CertificateInfo
+}
+
+// TimeoutCounts holds how many connections were closed by each timeout.
+type TimeoutCounts struct {
+	Header int `json:"header"`
+	Body   int `json:"body"`
+	Write  int `json:"write"`
+	Idle   int `json:"idle"`
+}
+
+// CertificateInfo describes a TLS certificate being served.
+type CertificateInfo struct {
+	Names    []string `json:"names"`
+	Serial   string   `json:"serial"`
+	NotAfter string   `json:"not_after"`
 }
@@ -14,14 +14,14 @@ func NewUserAgentHandler() *UserAgentHandler {
 }
 
 func (uah *UserAgentHandler) Handle(req *httpPkg.Request, res *httpPkg.Response) {
-	userAgent := req.Headers["User-Agent"]
+	userAgent := req.Headers.Get("User-Agent")
 	if userAgent == "" {
 		res.StatusCode = http.StatusBadRequest
 		return
 	}
 
 	res.StatusCode = http.StatusOK
-	res.Headers["Content-Type"] = "text/plain"
-	res.Headers["Content-Length"] = strconv.Itoa(len(userAgent))
+	res.Headers.Set("Content-Type", "text/plain")
+	res.Headers.Set("Content-Length", strconv.Itoa(len(userAgent)))
 	res.Body = userAgent
 }
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/config"
+)
+
+// maxChunkLineSize bounds chunk size and trailer lines.
+const maxChunkLineSize = 4096
+
+// chunkedReader decodes a body sent with "Transfer-Encoding: chunked".
+// Chunk extensions are ignored and trailer fields are collected in trailers
+// once the last chunk has been read, within maxTrailerBytes and
+// maxTrailerCount when positive.
+type chunkedReader struct {
+	reader   *bufio.Reader
+	left     int64 // bytes remaining in the current chunk
+	done     bool
+	trailers Header
+
+	maxTrailerBytes int
+	maxTrailerCount int
+}
+
+func newChunkedReader(reader *bufio.Reader, maxTrailerBytes, maxTrailerCount int) *chunkedReader {
+	return &chunkedReader{
+		reader:          reader,
+		trailers:        Header{},
+		maxTrailerBytes: maxTrailerBytes,
+		maxTrailerCount: maxTrailerCount,
+	}
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.done {
+		return 0, io.EOF
+	}
+
+	if cr.left == 0 {
+		size, err := cr.readChunkSize()
+		if err != nil {
+			return 0, err
+		}
+
+		if size == 0 {
+			if err := cr.readTrailers(); err != nil {
+				return 0, err
+			}
+			cr.done = true
+			return 0, io.EOF
+		}
+		cr.left = size
+	}
+
+	if int64(len(p)) > cr.left {
+		p = p[:cr.left]
+	}
+
+	n, err := cr.reader.Read(p)
+	cr.left -= int64(n)
+	if err != nil {
+		return n, unexpectedEOF(err)
+	}
+
+	// Every chunk's data is followed by CRLF
+	if cr.left == 0 {
+		line, err := readLine(cr.reader, maxChunkLineSize)
+		if err != nil {
+			return n, unexpectedEOF(err)
+		}
+		if line != "" {
+			return n, requestError(400, "malformed chunk: missing CRLF after data")
+		}
+	}
+
+	return n, nil
+}
+
+// readChunkSize parses a "size[;ext=value]" line.
+func (cr *chunkedReader) readChunkSize() (int64, error) {
+	line, err := readLine(cr.reader, maxChunkLineSize)
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimRight(line, " \t")
+
+	// ParseInt alone would also accept a sign or underscores
+	if line == "" || strings.IndexFunc(line, func(c rune) bool { return !isHexDigit(c) }) >= 0 {
+		return 0, requestError(400, "malformed chunk size: %q", line)
+	}
+	size, err := strconv.ParseInt(line, 16, 64)
+	if err != nil {
+		return 0, requestError(400, "malformed chunk size: %q", line)
+	}
+
+	return size, nil
+}
+
+func isHexDigit(c rune) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// readTrailers reads the trailer section, answered with 431 when over the
+// limits as the header section is.
+func (cr *chunkedReader) readTrailers() error {
+	trailerBytes, trailerCount := 0, 0
+	for {
+		// The empty line ending the section always fits
+		lineLimit := maxChunkLineSize
+		if cr.maxTrailerBytes > 0 {
+			lineLimit = min(lineLimit, max(cr.maxTrailerBytes-trailerBytes, 0)+len(config.CRLF))
+		}
+
+		line, err := readLine(cr.reader, lineLimit)
+		if err == errLineTooLong {
+			return requestError(431, "trailer section too large")
+		}
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		if line == "" {
+			return nil
+		}
+
+		trailerBytes += len(line) + len(config.CRLF)
+		trailerCount++
+		if cr.maxTrailerCount > 0 && trailerCount > cr.maxTrailerCount {
+			return requestError(431, "more than %d trailer fields", cr.maxTrailerCount)
+		}
+
+		trailerSplit := strings.SplitN(line, ":", 2)
+		if len(trailerSplit) == 2 {
+			cr.trailers.Add(strings.TrimSpace(trailerSplit[0]), strings.TrimSpace(trailerSplit[1]))
+		}
+	}
+}
+
+// isChunked reports whether the Transfer-Encoding header is exactly
+// "chunked". Other codings, even followed by chunked, are not decoded.
+func isChunked(transferEncoding string) bool {
+	return strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked")
+}
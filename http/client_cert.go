@@ -0,0 +1,50 @@
+package http
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+)
+
+// ClientCert describes the certificate a client presented over mutual TLS,
+// verified against the configured client CAs.
+type ClientCert struct {
+	// Subject is the distinguished name, e.g. "CN=billing,O=Internal"
+	Subject    string
+	CommonName string
+	// Subject alternative names
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []string
+	URIs           []string
+	// Fingerprint is the hex SHA-256 of the DER certificate
+	Fingerprint string
+	Certificate *x509.Certificate
+}
+
+// ClientCertFromState returns the verified client certificate of a TLS
+// connection, nil if the client did not present one or it was not verified.
+func ClientCertFromState(state *tls.ConnectionState) *ClientCert {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := state.VerifiedChains[0][0]
+	fingerprint := sha256.Sum256(cert.Raw)
+	clientCert := &ClientCert{
+		Subject:        cert.Subject.String(),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		Fingerprint:    hex.EncodeToString(fingerprint[:]),
+		Certificate:    cert,
+	}
+	for _, ip := range cert.IPAddresses {
+		clientCert.IPAddresses = append(clientCert.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		clientCert.URIs = append(clientCert.URIs, uri.String())
+	}
+	return clientCert
+}
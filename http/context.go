@@ -0,0 +1,78 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"maps"
+)
+
+// Causes of a cancelled request context, see context.Cause.
+var (
+	ErrClientDisconnected = errors.New("client disconnected")
+	ErrServerShutdown     = errors.New("server shut down")
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	principalKey
+	pathParamsKey
+)
+
+// Context returns the request context. For requests read by the server it
+// is cancelled when the client disconnects, the request deadline expires or
+// the server shuts down, never otherwise.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// SetContext replaces the request context, e.g. to add a value or a deadline
+// for the handlers down the chain.
+func (r *Request) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestIDFrom returns the request ID carried by ctx, if any.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithPrincipal returns a copy of ctx carrying the authenticated principal,
+// e.g. a user name or a client certificate subject.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// PrincipalFrom returns the authenticated principal carried by ctx, if any.
+func PrincipalFrom(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey).(string)
+	return principal
+}
+
+// WithPathParams returns a copy of ctx carrying params in addition to the
+// path parameters it already carries, e.g. from a mounting router.
+func WithPathParams(ctx context.Context, params map[string]string) context.Context {
+	merged := maps.Clone(PathParamsFrom(ctx))
+	if merged == nil {
+		merged = make(map[string]string, len(params))
+	}
+	maps.Copy(merged, params)
+	return context.WithValue(ctx, pathParamsKey, merged)
+}
+
+// PathParamsFrom returns the path parameters carried by ctx. The map must
+// not be modified.
+func PathParamsFrom(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(pathParamsKey).(map[string]string)
+	return params
+}
@@ -0,0 +1,36 @@
+package http
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRequestContext_DefaultsToBackground(t *testing.T) {
+	req := &Request{}
+	if req.Context() != context.Background() {
+		t.Error("Expected a request without context to return context.Background()")
+	}
+
+	ctx := WithRequestID(context.Background(), "abc")
+	req.SetContext(ctx)
+	if RequestIDFrom(req.Context()) != "abc" {
+		t.Errorf("Expected request ID 'abc', got '%s'", RequestIDFrom(req.Context()))
+	}
+}
+
+func TestContextValues(t *testing.T) {
+	ctx := WithPrincipal(context.Background(), "alice")
+	ctx = WithPathParams(ctx, map[string]string{"team": "core"})
+	ctx = WithPathParams(ctx, map[string]string{"id": "7"})
+
+	if PrincipalFrom(ctx) != "alice" {
+		t.Errorf("Expected principal 'alice', got '%s'", PrincipalFrom(ctx))
+	}
+	params := PathParamsFrom(ctx)
+	if params["team"] != "core" || params["id"] != "7" {
+		t.Errorf("Expected path params of both routers, got %v", params)
+	}
+	if RequestIDFrom(ctx) != "" {
+		t.Errorf("Expected no request ID, got '%s'", RequestIDFrom(ctx))
+	}
+}
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// errLineTooLong is returned by readLine for a line longer than its limit.
+var errLineTooLong = errors.New("line too long")
+
+// ErrHeaderTimeout and ErrBodyTimeout are wrapped by the 408 RequestError
+// returned when Limits.HeaderTimeout or Limits.BodyTimeout expire.
+var (
+	ErrHeaderTimeout = errors.New("timeout reading request header")
+	ErrBodyTimeout   = errors.New("timeout reading request body")
+)
+
+// RequestError is returned by ReadRequest and ReadBody for requests that
+// should be answered with StatusCode, e.g. 413 for a body over the limit,
+// before closing the connection.
+type RequestError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%d %s: %v", e.StatusCode, StatusText(e.StatusCode), e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func requestError(statusCode int, format string, args ...any) error {
+	return &RequestError{StatusCode: statusCode, Err: fmt.Errorf(format, args...)}
+}
+
+// timeoutError turns a network timeout into a 408 RequestError wrapping
+// phase, other errors are returned as is.
+func timeoutError(phase error, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &RequestError{StatusCode: 408, Err: fmt.Errorf("%w: %w", phase, err)}
+	}
+	return err
+}
+
+// ErrorStatus returns the status code a request error should be answered
+// with: the one of a RequestError, 400 otherwise.
+func ErrorStatus(err error) int {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.StatusCode
+	}
+	return 400
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"slices"
+	"strings"
+)
+
+// Header holds header fields keyed by their canonical name, see
+// CanonicalHeaderKey. A field sent several times keeps every value in order.
+// Use the methods rather than indexing the map so keys are canonicalized.
+type Header map[string][]string
+
+// Get returns the first value for key, or an empty string.
+func (h Header) Get(key string) string {
+	values := h[CanonicalHeaderKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Values returns every value for key.
+func (h Header) Values(key string) []string {
+	return h[CanonicalHeaderKey(key)]
+}
+
+// Has reports whether key is present, even with an empty value.
+func (h Header) Has(key string) bool {
+	_, ok := h[CanonicalHeaderKey(key)]
+	return ok
+}
+
+// Set replaces the values for key with value.
+func (h Header) Set(key, value string) {
+	h[CanonicalHeaderKey(key)] = []string{value}
+}
+
+// Add appends value to the values for key.
+func (h Header) Add(key, value string) {
+	key = CanonicalHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Del removes every value for key.
+func (h Header) Del(key string) {
+	delete(h, CanonicalHeaderKey(key))
+}
+
+// Clone returns a deep copy of h.
+func (h Header) Clone() Header {
+	if h == nil {
+		return nil
+	}
+	clone := make(Header, len(h))
+	for k, v := range h {
+		clone[k] = slices.Clone(v)
+	}
+	return clone
+}
+
+// CanonicalHeaderKey returns the canonical form of a header name: the first
+// letter and any letter following a hyphen upper case, the rest lower case,
+// so "content-length" becomes "Content-Length". Names containing characters
+// not allowed in a header name are returned unchanged.
+func CanonicalHeaderKey(key string) string {
+	upper := true
+	canonical := true
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !isTokenChar(c) {
+			return key
+		}
+		if (upper && 'a' <= c && c <= 'z') || (!upper && 'A' <= c && c <= 'Z') {
+			canonical = false
+		}
+		upper = c == '-'
+	}
+	if canonical {
+		return key
+	}
+
+	b := []byte(key)
+	upper = true
+	for i, c := range b {
+		if upper && 'a' <= c && c <= 'z' {
+			b[i] = c - ('a' - 'A')
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+		upper = c == '-'
+	}
+	return string(b)
+}
+
+// isTokenChar reports whether c may appear in a header name (RFC 9110 token).
+func isTokenChar(c byte) bool {
+	if c >= 0x80 || c <= ' ' || c == 0x7f {
+		return false
+	}
+	return !strings.ContainsRune(`"(),/:;<=>?@[\]{}`, rune(c))
+}
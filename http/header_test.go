@@ -0,0 +1,91 @@
+package http
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanonicalHeaderKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"content-length", "Content-Length"},
+		{"CONTENT-TYPE", "Content-Type"},
+		{"x-forwarded-for", "X-Forwarded-For"},
+		{"Host", "Host"},
+		{"bad key", "bad key"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := CanonicalHeaderKey(tt.key); got != tt.expected {
+			t.Errorf("CanonicalHeaderKey(%q) = %q, expected %q", tt.key, got, tt.expected)
+		}
+	}
+}
+
+func TestHeader_CaseInsensitive(t *testing.T) {
+	h := Header{}
+	h.Set("content-type", "text/plain")
+
+	if h.Get("Content-Type") != "text/plain" || h.Get("CONTENT-TYPE") != "text/plain" {
+		t.Errorf("Expected Content-Type to be readable in any case, got %v", h)
+	}
+
+	h.Del("CONTENT-type")
+	if h.Has("Content-Type") {
+		t.Errorf("Expected Content-Type to be deleted, got %v", h)
+	}
+}
+
+func TestHeader_MultipleValues(t *testing.T) {
+	h := Header{}
+	h.Add("Cookie", "a=1")
+	h.Add("cookie", "b=2")
+
+	if got := h.Values("Cookie"); len(got) != 2 || got[0] != "a=1" || got[1] != "b=2" {
+		t.Errorf("Expected both Cookie values, got %v", got)
+	}
+	if h.Get("Cookie") != "a=1" {
+		t.Errorf("Expected Get to return the first value, got '%s'", h.Get("Cookie"))
+	}
+
+	h.Set("Cookie", "c=3")
+	if got := h.Values("Cookie"); len(got) != 1 || got[0] != "c=3" {
+		t.Errorf("Expected Set to replace every value, got %v", got)
+	}
+}
+
+func TestParseRequest_RepeatedAndLowercaseHeaders(t *testing.T) {
+	data := "POST /files/a HTTP/1.1\r\naccept: text/html\r\nAccept: application/json\r\ncontent-length: 5\r\n\r\nhello"
+	req, err := ParseRequest(&testConn{data: data})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := req.Headers.Values("Accept"); len(got) != 2 || got[0] != "text/html" || got[1] != "application/json" {
+		t.Errorf("Expected both Accept values, got %v", got)
+	}
+	if req.Body != "hello" {
+		t.Errorf("Expected body 'hello' from lowercase content-length, got '%s'", req.Body)
+	}
+}
+
+func TestSendToClient_RepeatedResponseHeaders(t *testing.T) {
+	conn := &recordingConn{}
+	req := &Request{Headers: Header{}, Connection: conn}
+	resp := NewResponse(req)
+	resp.StatusCode = 200
+	resp.Headers.Add("Set-Cookie", "a=1")
+	resp.Headers.Add("set-cookie", "b=2")
+
+	if err := resp.SendToClient(req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	out := conn.written.String()
+	if !strings.Contains(out, "Set-Cookie:a=1\r\n") || !strings.Contains(out, "Set-Cookie:b=2\r\n") {
+		t.Errorf("Expected one Set-Cookie line per value, got %q", out)
+	}
+}
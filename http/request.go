@@ -1,79 +1,387 @@
 package http
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/codecrafters-io/http-server-starter-go/config"
 )
 
 type Request struct {
-	Path       string
-	Headers    map[string]string
+	// Path is the percent-decoded path of the request target, without the
+	// query, e.g. "/echo/hello world" for "/echo/hello%20world?x=1"
+	Path string
+	// RawPath is the path as sent by the client, still percent-encoded
+	RawPath string
+	// RawQuery is the query as sent by the client, without the '?'
+	RawQuery string
+	// Query holds the decoded query parameters, a name may have several values
+	Query      url.Values
+	Headers    Header
 	Connection net.Conn
 	Method     string
-	Body       string
+	// Body is empty until ReadBody is called when the client sent
+	// "Expect: 100-continue", it is read by the parser otherwise
+	Body string
+	// Trailers holds trailer fields sent after a chunked body
+	Trailers Header
+	// ClientCert is the verified certificate the client authenticated with
+	// over mutual TLS, nil otherwise
+	ClientCert *ClientCert
+	// pathParams holds the values captured by the matched route pattern
+	pathParams map[string]string
+	// pendingBody holds the reader of a body the client only sends after a
+	// 100 Continue, until ReadBody is called
+	pendingBody *bufio.Reader
+	bodyErr     error
+	maxBodySize int64
+	bodyTimeout time.Duration
+	// maxTrailerBytes and maxTrailerCount bound the trailers of a chunked
+	// body like the header section
+	maxTrailerBytes int
+	maxTrailerCount int
+	ctx             context.Context
 }
 
-func ParseRequest(conn net.Conn) (*Request, error) {
-	// Create a buffer and read the HTTP request from connection
-	buffer := make([]byte, config.BufferSize)
-	n, err := conn.Read(buffer)
+// Limits bounds the requests read by ReadRequest, zero means no limit.
+type Limits struct {
+	// HeaderTimeout bounds the time to read the request line and headers,
+	// and BodyTimeout the time to read the body once started. Both are
+	// answered with 408.
+	HeaderTimeout time.Duration
+	BodyTimeout   time.Duration
+	// MaxRequestLineSize is the size of the request line, larger ones are
+	// answered with 414
+	MaxRequestLineSize int
+	// MaxHeaderBytes and MaxHeaderCount bound the header section, and the
+	// trailer section of a chunked body, larger ones are answered with 431
+	MaxHeaderBytes int
+	MaxHeaderCount int
+	// MaxBodySize is the size of the body, larger ones are answered with 413
+	MaxBodySize int64
+}
+
+// PathParam returns the value captured for name by the route pattern, e.g.
+// "{name}" or "*rest", or an empty string if there is none.
+func (r *Request) PathParam(name string) string {
+	return r.pathParams[name]
+}
+
+// SetPathParam records a value captured by the router for name.
+func (r *Request) SetPathParam(name, value string) {
+	if r.pathParams == nil {
+		r.pathParams = make(map[string]string)
+	}
+	r.pathParams[name] = value
+}
+
+// SetTarget splits a request target such as "/echo/hello%20world?x=1" into
+// Path, RawPath, RawQuery and Query. Only a malformed path is an error, as
+// with net/http Query holds the pairs of a malformed query that parsed.
+func (r *Request) SetTarget(target string) error {
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+
+	path, err := url.PathUnescape(rawPath)
 	if err != nil {
-		if err == io.EOF {
-			return nil, err // No more data client closed the connection
-		}
-		return nil, fmt.Errorf("failed to read from connection: %w", err)
+		return fmt.Errorf("malformed request path %q: %w", rawPath, err)
 	}
+	query, _ := url.ParseQuery(rawQuery)
+
+	r.Path = path
+	r.RawPath = rawPath
+	r.RawQuery = rawQuery
+	r.Query = query
+	return nil
+}
 
-	if n == 0 {
-		return nil, nil // No data received
+// RequestURI returns the target as sent by the client, path and query. For
+// requests built without RawPath, Path is escaped instead.
+func (r *Request) RequestURI() string {
+	rawPath := r.RawPath
+	if rawPath == "" {
+		rawPath = (&url.URL{Path: r.Path}).EscapedPath()
 	}
 
-	req := string(buffer[:n])
-	lines := strings.Split(req, "\r\n")
+	if r.RawQuery == "" {
+		return rawPath
+	}
+	return rawPath + "?" + r.RawQuery
+}
+
+// ParseRequest reads a single request from conn using a fresh buffered
+// reader and no limits.
+func ParseRequest(conn net.Conn) (*Request, error) {
+	return ReadRequest(bufio.NewReaderSize(conn, config.BufferSize), conn, Limits{})
+}
 
-	if len(lines) == 0 {
-		return nil, fmt.Errorf("empty request")
+// ReadRequest reads the request line and headers from reader until the empty
+// line ending the header section, then reads the body: either exactly
+// Content-Length bytes, or a decoded "Transfer-Encoding: chunked" body.
+// A body announced with "Expect: 100-continue" is left for ReadBody.
+// Reads may span any number of underlying conn.Read calls.
+//
+// Requests over limits, or malformed, return a *RequestError holding the
+// status code to answer with.
+func ReadRequest(reader *bufio.Reader, conn net.Conn, limits Limits) (*Request, error) {
+	if conn != nil && limits.HeaderTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(limits.HeaderTimeout))
+	}
+
+	requestLine, err := readLine(reader, limits.MaxRequestLineSize)
+	if err == errLineTooLong {
+		return nil, requestError(414, "request line over %d bytes", limits.MaxRequestLineSize)
+	}
+	if err != nil {
+		return nil, timeoutError(ErrHeaderTimeout, err) // io.EOF means the client closed the connection
 	}
 
 	// Parse the request line (e.g., "GET /path HTTP/1.1")
-	requestLineParts := strings.Split(lines[0], " ")
+	requestLineParts := strings.Split(requestLine, " ")
 	if len(requestLineParts) < 2 {
-		return nil, fmt.Errorf("malformed request line: %s", lines[0])
+		return nil, requestError(400, "malformed request line: %s", requestLine)
 	}
 
 	//Read path and method type
 	request := Request{
-		Path:       requestLineParts[1],
-		Method:     requestLineParts[0],
-		Connection: conn,
-		Headers:    make(map[string]string),
+		Method:      requestLineParts[0],
+		Connection:  conn,
+		Headers:     Header{},
+		maxBodySize: limits.MaxBodySize,
+		bodyTimeout: limits.BodyTimeout,
+
+		maxTrailerBytes: limits.MaxHeaderBytes,
+		maxTrailerCount: limits.MaxHeaderCount,
+	}
+	if err := request.SetTarget(requestLineParts[1]); err != nil {
+		return nil, &RequestError{StatusCode: 400, Err: err}
 	}
 
 	// Parse headers
-	for _, line := range lines[1:] {
-		// If line is empty, there is no more header, next line is the body
+	headerBytes, headerCount := 0, 0
+	for {
+		// The empty line ending the section always fits
+		lineLimit := 0
+		if limits.MaxHeaderBytes > 0 {
+			lineLimit = max(limits.MaxHeaderBytes-headerBytes, 0) + len(config.CRLF)
+		}
+
+		line, err := readLine(reader, lineLimit)
+		if err == errLineTooLong {
+			return nil, requestError(431, "header section over %d bytes", limits.MaxHeaderBytes)
+		}
+		if err != nil {
+			return nil, timeoutError(ErrHeaderTimeout, unexpectedEOF(err))
+		}
+
+		// If line is empty, there is no more header, next is the body
 		if line == "" {
 			break
 		}
 
+		headerBytes += len(line) + len(config.CRLF)
+		headerCount++
+		if limits.MaxHeaderCount > 0 && headerCount > limits.MaxHeaderCount {
+			return nil, requestError(431, "more than %d header fields", limits.MaxHeaderCount)
+		}
+
 		headerSplit := strings.SplitN(line, ":", 2)
 		for i, v := range headerSplit {
 			headerSplit[i] = strings.TrimSpace(v)
 		}
 		if len(headerSplit) == 2 {
-			request.Headers[headerSplit[0]] = headerSplit[1]
+			request.Headers.Add(headerSplit[0], headerSplit[1])
 		}
 	}
 
-	// Read Body
-	bodyStart := strings.Index(req, "\r\n\r\n") + 4
-	if bodyStart < len(req) {
-		request.Body = req[bodyStart:]
+	length, chunked, err := request.bodyFraming()
+	if err != nil {
+		return nil, err
+	}
+	if err := request.checkBodySize(length); err != nil {
+		return nil, err
+	}
+
+	// The client waits for a 100 Continue before sending the body, it is
+	// read once a handler asks for it
+	if (length > 0 || chunked) && strings.EqualFold(request.Headers.Get("Expect"), "100-continue") {
+		request.pendingBody = reader
+		return &request, nil
+	}
+
+	if err := request.readBody(reader, length, chunked); err != nil {
+		return nil, err
 	}
 
 	return &request, nil
 }
+
+// ReadBody returns the request body. If the client sent
+// "Expect: 100-continue" the first call answers with 100 Continue and reads
+// the body, so a handler can reject an upload before it is transmitted by
+// not calling ReadBody.
+func (r *Request) ReadBody() (string, error) {
+	if r.pendingBody == nil {
+		return r.Body, r.bodyErr
+	}
+
+	reader := r.pendingBody
+	r.pendingBody = nil
+
+	// Validated by ReadRequest, the size limit may have been lowered since
+	length, chunked, _ := r.bodyFraming()
+	if r.bodyErr = r.checkBodySize(length); r.bodyErr != nil {
+		return "", r.bodyErr
+	}
+
+	if r.Connection != nil {
+		if _, err := io.WriteString(r.Connection, "HTTP/1.1 100 Continue"+config.CRLF+config.CRLF); err != nil {
+			r.bodyErr = fmt.Errorf("failed to send 100 Continue: %w", err)
+			return "", r.bodyErr
+		}
+	}
+
+	r.bodyErr = r.readBody(reader, length, chunked)
+	return r.Body, r.bodyErr
+}
+
+// SetMaxBodySize lowers the body size limit of the request to limit bytes,
+// e.g. for a single route. It applies to a body not read yet, one already
+// read has to be checked against Body.
+func (r *Request) SetMaxBodySize(limit int64) {
+	if r.maxBodySize <= 0 || limit < r.maxBodySize {
+		r.maxBodySize = limit
+	}
+}
+
+// BodyConsumed reports whether the body has been entirely read from the
+// connection, so the next request on it can be parsed. It is false when the
+// body announced with "Expect: 100-continue" was never read or failed to.
+func (r *Request) BodyConsumed() bool {
+	return r.pendingBody == nil && r.bodyErr == nil
+}
+
+// bodyFraming returns the length of the body from Content-Length, or whether
+// it is chunked. A request with both is rejected rather than guessing which
+// one an intermediary used, as that is how requests get smuggled.
+func (r *Request) bodyFraming() (length int, chunked bool, err error) {
+	if r.Headers.Has("Transfer-Encoding") {
+		if r.Headers.Has("Content-Length") {
+			return 0, false, requestError(400, "both Transfer-Encoding and Content-Length are set")
+		}
+		transferEncoding := strings.Join(r.Headers.Values("Transfer-Encoding"), ",")
+		if !isChunked(transferEncoding) {
+			return 0, false, requestError(501, "unsupported Transfer-Encoding: %q", transferEncoding)
+		}
+		return 0, true, nil
+	}
+
+	if !r.Headers.Has("Content-Length") {
+		return 0, false, nil
+	}
+
+	// Repeated identical values are allowed, different ones are not
+	contentLength := r.Headers.Get("Content-Length")
+	for _, value := range r.Headers.Values("Content-Length") {
+		if value != contentLength {
+			return 0, false, requestError(400, "conflicting Content-Length values: %q", r.Headers.Values("Content-Length"))
+		}
+	}
+
+	length, err = strconv.Atoi(contentLength)
+	if err != nil || length < 0 {
+		return 0, false, requestError(400, "invalid Content-Length: %q", contentLength)
+	}
+
+	return length, false, nil
+}
+
+// checkBodySize returns a 413 error if length is over the body size limit.
+func (r *Request) checkBodySize(length int) error {
+	if r.maxBodySize > 0 && int64(length) > r.maxBodySize {
+		return requestError(413, "body of %d bytes over the %d bytes limit", length, r.maxBodySize)
+	}
+	return nil
+}
+
+// readBody reads either exactly length bytes or a chunked body from reader,
+// within the body timeout if any.
+func (r *Request) readBody(reader *bufio.Reader, length int, chunked bool) error {
+	if r.Connection != nil && r.bodyTimeout > 0 && (length > 0 || chunked) {
+		r.Connection.SetReadDeadline(time.Now().Add(r.bodyTimeout))
+	}
+
+	if chunked {
+		chunkedReader := newChunkedReader(reader, r.maxTrailerBytes, r.maxTrailerCount)
+
+		// The size of a chunked body is only known once read, read one byte
+		// past the limit to detect larger ones
+		var body []byte
+		var err error
+		if r.maxBodySize > 0 {
+			body, err = io.ReadAll(io.LimitReader(chunkedReader, r.maxBodySize+1))
+		} else {
+			body, err = io.ReadAll(chunkedReader)
+		}
+		if err != nil {
+			return timeoutError(ErrBodyTimeout, fmt.Errorf("failed to read chunked request body: %w", err))
+		}
+		if r.maxBodySize > 0 && int64(len(body)) > r.maxBodySize {
+			return requestError(413, "chunked body over the %d bytes limit", r.maxBodySize)
+		}
+		r.Body = string(body)
+		r.Trailers = chunkedReader.trailers
+		return nil
+	}
+
+	// The buffer grows as the data arrives rather than trusting the
+	// announced length, which may be huge when no limit is set
+	var body bytes.Buffer
+	if _, err := io.CopyN(&body, reader, int64(length)); err != nil {
+		return timeoutError(ErrBodyTimeout, fmt.Errorf("failed to read request body: %w", unexpectedEOF(err)))
+	}
+	r.Body = body.String()
+	return nil
+}
+
+// readLine returns the next line without its trailing CRLF (or bare LF). If
+// limit is positive, lines longer than limit bytes, CRLF included, return
+// errLineTooLong without being read entirely.
+func readLine(reader *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		fragment, err := reader.ReadSlice('\n')
+		line = append(line, fragment...)
+		if limit > 0 && len(line) > limit {
+			return "", errLineTooLong
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		break
+	}
+
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return string(bytes.TrimSuffix(line, []byte("\r"))), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
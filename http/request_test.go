@@ -1,10 +1,18 @@
 package http
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/config"
 )
 
 // testConn is a mock connection that implements io.Reader for testing.
@@ -23,6 +31,20 @@ func (c *testConn) Read(p []byte) (int, error) {
 	return n, nil
 }
 
+// chunkedConn returns at most chunkSize bytes per Read to simulate data
+// arriving across several TCP segments.
+type chunkedConn struct {
+	testConn
+	chunkSize int
+}
+
+func (c *chunkedConn) Read(p []byte) (int, error) {
+	if len(p) > c.chunkSize {
+		p = p[:c.chunkSize]
+	}
+	return c.testConn.Read(p)
+}
+
 func TestParseRequest_EmptyBody(t *testing.T) {
 	data := "GET /empty HTTP/1.1\r\nHost: localhost\r\n\r\n"
 	conn := &testConn{data: data}
@@ -45,14 +67,14 @@ func TestParseRequest_MultipleHeaders(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
-	if req.Headers["Host"] != "example.com" {
-		t.Errorf("Expected Host 'example.com', got '%s'", req.Headers["Host"])
+	if req.Headers.Get("Host") != "example.com" {
+		t.Errorf("Expected Host 'example.com', got '%s'", req.Headers.Get("Host"))
 	}
-	if req.Headers["Content-Type"] != "application/json" {
-		t.Errorf("Expected Content-Type 'application/json', got '%s'", req.Headers["Content-Type"])
+	if req.Headers.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected Content-Type 'application/json', got '%s'", req.Headers.Get("Content-Type"))
 	}
-	if req.Headers["Authorization"] != "Bearer token123" {
-		t.Errorf("Expected Authorization 'Bearer token123', got '%s'", req.Headers["Authorization"])
+	if req.Headers.Get("Authorization") != "Bearer token123" {
+		t.Errorf("Expected Authorization 'Bearer token123', got '%s'", req.Headers.Get("Authorization"))
 	}
 }
 
@@ -76,11 +98,11 @@ func TestParseRequest_HeadersWithSpaces(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
-	if req.Headers["User-Agent"] != "Mozilla/5.0" {
-		t.Errorf("Expected User-Agent 'Mozilla/5.0', got '%s'", req.Headers["User-Agent"])
+	if req.Headers.Get("User-Agent") != "Mozilla/5.0" {
+		t.Errorf("Expected User-Agent 'Mozilla/5.0', got '%s'", req.Headers.Get("User-Agent"))
 	}
-	if req.Headers["Accept"] != "text/html" {
-		t.Errorf("Expected Accept 'text/html', got '%s'", req.Headers["Accept"])
+	if req.Headers.Get("Accept") != "text/html" {
+		t.Errorf("Expected Accept 'text/html', got '%s'", req.Headers.Get("Accept"))
 	}
 }
 
@@ -111,7 +133,359 @@ func TestParseRequest(t *testing.T) {
 	if request.Method != "GET" {
 		t.Errorf("expected Method 'GET', got '%s'", request.Method)
 	}
-	if ua := request.Headers["User-Agent"]; ua != "test-agent" {
+	if ua := request.Headers.Get("User-Agent"); ua != "test-agent" {
 		t.Errorf("expected User-Agent 'test-agent', got '%s'", ua)
 	}
 }
+
+func TestParseRequest_SplitAcrossReads(t *testing.T) {
+	body := "name=value&other=thing"
+	data := fmt.Sprintf("POST /form HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	conn := &chunkedConn{testConn: testConn{data: data}, chunkSize: 3}
+	req, err := ParseRequest(conn)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if req.Method != "POST" || req.Path != "/form" {
+		t.Errorf("Expected POST /form, got %s %s", req.Method, req.Path)
+	}
+	if req.Headers.Get("Host") != "localhost" {
+		t.Errorf("Expected Host 'localhost', got '%s'", req.Headers.Get("Host"))
+	}
+	if req.Body != body {
+		t.Errorf("Expected body '%s', got '%s'", body, req.Body)
+	}
+}
+
+func TestParseRequest_BodyLargerThanBuffer(t *testing.T) {
+	body := strings.Repeat("0123456789", 10*config.BufferSize)
+	data := fmt.Sprintf("POST /files/big HTTP/1.1\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	conn := &chunkedConn{testConn: testConn{data: data}, chunkSize: 1500}
+	req, err := ParseRequest(conn)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(req.Body) != len(body) {
+		t.Fatalf("Expected body of %d bytes, got %d", len(body), len(req.Body))
+	}
+	if req.Body != body {
+		t.Error("Body content mismatch")
+	}
+}
+
+func TestParseRequest_TruncatedBody(t *testing.T) {
+	data := "POST /upload HTTP/1.1\r\nContent-Length: 10\r\n\r\nshort"
+	conn := &testConn{data: data}
+	if _, err := ParseRequest(conn); err == nil {
+		t.Error("Expected error for body shorter than Content-Length")
+	}
+}
+
+func TestParseRequest_HugeContentLengthWithoutLimit(t *testing.T) {
+	// Nothing is allocated for the announced length before the data arrives
+	data := "POST /upload HTTP/1.1\r\nContent-Length: 100000000000\r\n\r\nshort"
+	conn := &testConn{data: data}
+	if _, err := ParseRequest(conn); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected an unexpected EOF for the truncated body, got %v", err)
+	}
+}
+
+func TestParseRequest_TruncatedHeaders(t *testing.T) {
+	data := "GET /test HTTP/1.1\r\nHost: localhost\r\n"
+	conn := &testConn{data: data}
+	if _, err := ParseRequest(conn); err == nil {
+		t.Error("Expected error for headers without terminating empty line")
+	}
+}
+
+func TestParseRequest_ChunkedBody(t *testing.T) {
+	data := "POST /files/chunked HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n" +
+		"7;name=value\r\n, world\r\n" +
+		"0\r\n\r\n"
+	conn := &chunkedConn{testConn: testConn{data: data}, chunkSize: 4}
+	req, err := ParseRequest(conn)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if req.Body != "hello, world" {
+		t.Errorf("Expected body 'hello, world', got '%s'", req.Body)
+	}
+}
+
+func TestParseRequest_ChunkedBodyWithTrailers(t *testing.T) {
+	data := "POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"A\r\n0123456789\r\n" +
+		"0\r\nChecksum: abc123\r\nExpires: never\r\n\r\n"
+	conn := &testConn{data: data}
+	req, err := ParseRequest(conn)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if req.Body != "0123456789" {
+		t.Errorf("Expected body '0123456789', got '%s'", req.Body)
+	}
+	if req.Trailers.Get("Checksum") != "abc123" {
+		t.Errorf("Expected Checksum trailer 'abc123', got '%s'", req.Trailers.Get("Checksum"))
+	}
+	if req.Trailers.Get("Expires") != "never" {
+		t.Errorf("Expected Expires trailer 'never', got '%s'", req.Trailers.Get("Expires"))
+	}
+}
+
+func TestParseRequest_ChunkedWithContentLengthRejected(t *testing.T) {
+	data := "POST /upload HTTP/1.1\r\nContent-Length: 100\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"3\r\nabc\r\n0\r\n\r\n"
+	conn := &testConn{data: data}
+	if _, err := ParseRequest(conn); ErrorStatus(err) != 400 {
+		t.Errorf("Expected a 400 error for both Content-Length and Transfer-Encoding, got %v", err)
+	}
+}
+
+func TestParseRequest_MalformedChunked(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		truncated bool
+	}{
+		{"Invalid size", "zz\r\nabc\r\n0\r\n\r\n", false},
+		{"Signed size", "+3\r\nabc\r\n0\r\n\r\n", false},
+		{"Prefixed size", "0x3\r\nabc\r\n0\r\n\r\n", false},
+		{"Underscore in size", "0_3\r\nabc\r\n0\r\n\r\n", false},
+		{"Empty size", "\r\nabc\r\n0\r\n\r\n", false},
+		{"Missing CRLF after data", "3\r\nabcdef\r\n0\r\n\r\n", false},
+		{"Missing last chunk", "3\r\nabc\r\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := "POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" + tt.body
+			conn := &testConn{data: data}
+			_, err := ParseRequest(conn)
+			if err == nil {
+				t.Fatal("Expected error for malformed chunked body")
+			}
+
+			// Malformed framing is a 400 RequestError, a truncated body is
+			// left to the caller
+			var reqErr *RequestError
+			if !tt.truncated && (!errors.As(err, &reqErr) || reqErr.StatusCode != 400) {
+				t.Errorf("Expected a 400 RequestError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseRequest_UnsupportedTransferEncoding(t *testing.T) {
+	for _, transferEncoding := range []string{"gzip", "gzip, chunked", "chunked, chunked"} {
+		data := "POST /upload HTTP/1.1\r\nTransfer-Encoding: " + transferEncoding + "\r\n\r\n3\r\nabc\r\n0\r\n\r\n"
+		conn := &testConn{data: data}
+		if _, err := ParseRequest(conn); ErrorStatus(err) != 501 {
+			t.Errorf("Transfer-Encoding %q: expected a 501 error, got %v", transferEncoding, err)
+		}
+	}
+}
+
+func TestParseRequest_TargetDecoding(t *testing.T) {
+	data := "GET /echo/hello%20world?x=1&x=2&name=a%26b HTTP/1.1\r\n\r\n"
+	req, err := ParseRequest(&testConn{data: data})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if req.Path != "/echo/hello world" {
+		t.Errorf("Expected decoded path '/echo/hello world', got '%s'", req.Path)
+	}
+	if req.RawPath != "/echo/hello%20world" {
+		t.Errorf("Expected raw path '/echo/hello%%20world', got '%s'", req.RawPath)
+	}
+	if got := req.Query["x"]; len(got) != 2 || got[0] != "1" || got[1] != "2" {
+		t.Errorf("Expected both x values, got %v", got)
+	}
+	if req.Query.Get("name") != "a&b" {
+		t.Errorf("Expected name 'a&b', got '%s'", req.Query.Get("name"))
+	}
+	if req.RequestURI() != "/echo/hello%20world?x=1&x=2&name=a%26b" {
+		t.Errorf("Expected the original target back, got '%s'", req.RequestURI())
+	}
+}
+
+func TestParseRequest_MalformedEscape(t *testing.T) {
+	data := "GET /files/%zz HTTP/1.1\r\n\r\n"
+	if _, err := ParseRequest(&testConn{data: data}); err == nil {
+		t.Error("Expected an error for a malformed path")
+	}
+}
+
+func TestParseRequest_MalformedQuery(t *testing.T) {
+	tests := []struct {
+		target   string
+		rawQuery string
+		query    url.Values
+	}{
+		{"/echo/a?x=1;y=2", "x=1;y=2", url.Values{}},
+		{"/echo/a?x=1;y=2&z=3", "x=1;y=2&z=3", url.Values{"z": {"3"}}},
+		{"/echo/a?x=%&y=2", "x=%&y=2", url.Values{"y": {"2"}}},
+	}
+
+	for _, tt := range tests {
+		data := "GET " + tt.target + " HTTP/1.1\r\n\r\n"
+		req, err := ParseRequest(&testConn{data: data})
+		if err != nil {
+			t.Errorf("Target %q: unexpected error: %v", tt.target, err)
+			continue
+		}
+
+		if req.Path != "/echo/a" || req.RawQuery != tt.rawQuery {
+			t.Errorf("Target %q: expected path '/echo/a' and raw query %q, got %q and %q", tt.target, tt.rawQuery, req.Path, req.RawQuery)
+		}
+		if !reflect.DeepEqual(req.Query, tt.query) {
+			t.Errorf("Target %q: expected query %v, got %v", tt.target, tt.query, req.Query)
+		}
+	}
+}
+
+// duplexConn serves data to reads and records writes.
+type duplexConn struct {
+	testConn
+	written strings.Builder
+}
+
+func (c *duplexConn) Write(b []byte) (int, error) { return c.written.Write(b) }
+
+func TestParseRequest_ExpectContinue(t *testing.T) {
+	data := "POST /files/a HTTP/1.1\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\nhello"
+	conn := &duplexConn{testConn: testConn{data: data}}
+	req, err := ParseRequest(conn)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if req.Body != "" || req.BodyConsumed() {
+		t.Fatalf("Expected the body to wait for ReadBody, got '%s'", req.Body)
+	}
+	if conn.written.Len() != 0 {
+		t.Fatalf("Expected nothing written before ReadBody, got %q", conn.written.String())
+	}
+
+	body, err := req.ReadBody()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if body != "hello" || req.Body != "hello" || !req.BodyConsumed() {
+		t.Errorf("Expected body 'hello', got '%s'", body)
+	}
+	if conn.written.String() != "HTTP/1.1 100 Continue\r\n\r\n" {
+		t.Errorf("Expected a 100 Continue, got %q", conn.written.String())
+	}
+
+	// Later calls return the same body without another interim response
+	if body, _ := req.ReadBody(); body != "hello" || conn.written.Len() != len("HTTP/1.1 100 Continue\r\n\r\n") {
+		t.Errorf("Expected ReadBody to be idempotent, got '%s' and %q", body, conn.written.String())
+	}
+}
+
+func TestParseRequest_ExpectContinueWithoutBody(t *testing.T) {
+	data := "POST /files/a HTTP/1.1\r\nContent-Length: 0\r\nExpect: 100-continue\r\n\r\n"
+	req, err := ParseRequest(&testConn{data: data})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !req.BodyConsumed() {
+		t.Error("Expected an empty body not to wait for 100 Continue")
+	}
+}
+
+func TestReadRequest_Limits(t *testing.T) {
+	limits := Limits{MaxRequestLineSize: 64, MaxHeaderBytes: 128, MaxHeaderCount: 4, MaxBodySize: 16}
+
+	tests := []struct {
+		name           string
+		data           string
+		expectedStatus int
+	}{
+		{"Within limits", "POST /a HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello", 0},
+		{"Request line too long", "GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\n\r\n", 414},
+		{"Header section too large", "GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", 128) + "\r\n\r\n", 431},
+		{"Too many headers", "GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\nE: 5\r\n\r\n", 431},
+		{"Body too large", "POST /a HTTP/1.1\r\nContent-Length: 17\r\n\r\n" + strings.Repeat("a", 17), 413},
+		{"Chunked body too large", "POST /a HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n10\r\n" + strings.Repeat("a", 16) + "\r\n1\r\na\r\n0\r\n\r\n", 413},
+		{"Trailers within limits", "POST /a HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nA: 1\r\nB: 2\r\n\r\n", 0},
+		{"Trailer section too large", "POST /a HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-Big: " + strings.Repeat("a", 128) + "\r\n\r\n", 431},
+		{"Too many trailers", "POST /a HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\nE: 5\r\n\r\n", 431},
+		{"Malformed request line", "GET\r\n\r\n", 400},
+		{"Unsupported Transfer-Encoding", "POST /a HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n", 501},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReaderSize(&testConn{data: tt.data}, 16)
+			_, err := ReadRequest(reader, nil, limits)
+
+			if tt.expectedStatus == 0 {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			var reqErr *RequestError
+			if !errors.As(err, &reqErr) {
+				t.Fatalf("Expected a RequestError, got %v", err)
+			}
+			if reqErr.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, reqErr.StatusCode)
+			}
+		})
+	}
+}
+
+func TestReadBody_LoweredLimitBeforeContinue(t *testing.T) {
+	data := "POST /files/a HTTP/1.1\r\nContent-Length: 10\r\nExpect: 100-continue\r\n\r\n"
+	conn := &duplexConn{testConn: testConn{data: data}}
+	req, err := ParseRequest(conn)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	req.SetMaxBodySize(5)
+	if _, err := req.ReadBody(); ErrorStatus(err) != 413 {
+		t.Errorf("Expected a 413 error, got %v", err)
+	}
+	if conn.written.Len() != 0 {
+		t.Errorf("Expected no 100 Continue for a rejected body, got %q", conn.written.String())
+	}
+	if req.BodyConsumed() {
+		t.Error("Expected the rejected body to be left unread")
+	}
+}
+
+func TestReadRequest_Timeouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected error
+	}{
+		{"Header", "GET / HTTP/1.1\r\nHost: local", ErrHeaderTimeout},
+		{"Body", "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nabc", ErrBodyTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			go client.Write([]byte(tt.data))
+
+			limits := Limits{HeaderTimeout: 50 * time.Millisecond, BodyTimeout: 50 * time.Millisecond}
+			_, err := ReadRequest(bufio.NewReader(server), server, limits)
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, err)
+			}
+			if ErrorStatus(err) != 408 {
+				t.Errorf("Expected status 408, got %d", ErrorStatus(err))
+			}
+		})
+	}
+}
@@ -1,59 +1,204 @@
 package http
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 
 	"github.com/codecrafters-io/http-server-starter-go/config"
 )
 
 type Response struct {
 	StatusCode int
+	// StatusMessage overrides the canonical reason phrase when set
+	StatusMessage string
+	// Body is the body set as a whole by a handler, data passed to Write is
+	// buffered separately, see BufferedBody
 	Body       string
-	Headers    map[string]string
+	Headers    Header
 	Connection net.Conn
+
+	// buf holds the data written and not sent yet
+	buf bytes.Buffer
+
+	// sink receives the response once committed, it writes to Connection
+	// unless the response was created with NewResponseWithSink
+	sink ResponseSink
+	// committed is set once the status line and headers have been written
+	committed bool
+	// omitBody is set for responses to HEAD requests, the headers describe
+	// the body a GET would get but the body itself is never sent
+	omitBody bool
+	// aborted is set by Abort, the response is never completed
+	aborted bool
+}
+
+// ErrAbortedResponse is returned by SendToClient for an aborted response.
+var ErrAbortedResponse = errors.New("response aborted")
+
+// ResponseSink is where a committed Response is sent. The default one writes
+// HTTP/1.1 to the connection, other sinks let the same handlers run behind
+// something else, like a net/http server.
+type ResponseSink interface {
+	// WriteHeader sends the status line and headers
+	WriteHeader(statusCode int, statusMessage string, headers Header) error
+	// Write sends part of the body
+	Write(p []byte) (int, error)
+	// Flush pushes anything buffered to the client
+	Flush() error
+	// Close ends the response, sending anything still buffered
+	Close() error
 }
 
 func NewResponse(request *Request) *Response {
 	response := &Response{
-		Headers: map[string]string{},
+		Headers: Header{},
 	}
 
-	if request.Headers["Connection"] == "close" {
-		response.Headers["Connection"] = "close"
+	if strings.EqualFold(request.Headers.Get("Connection"), "close") {
+		response.Headers.Set("Connection", "close")
 	}
 
 	response.Connection = request.Connection
+	response.omitBody = request.Method == "HEAD"
 
 	return response
 }
 
-func (r *Response) SendToClient(request *Request) error {
-	var statusMessage string
-	switch r.StatusCode {
-	case 404:
-		statusMessage = "Not Found"
-	case 201:
-		statusMessage = "Created"
-	case 400:
-		statusMessage = "Bad request"
-	case 200:
-		statusMessage = "OK"
-	default:
-		return fmt.Errorf("unknown status code: %d", r.StatusCode)
+// NewResponseWithSink creates a response to request that is sent to sink
+// instead of the request connection.
+func NewResponseWithSink(request *Request, sink ResponseSink) *Response {
+	response := NewResponse(request)
+	response.sink = sink
+	return response
+}
+
+// WriteHeader sets the status code, it has no effect once the response is committed.
+func (r *Response) WriteHeader(statusCode int) {
+	if r.committed {
+		return
+	}
+	r.StatusCode = statusCode
+}
+
+// Write appends p to the response body. Up to config.BufferSize bytes are
+// buffered so middlewares can still inspect or rewrite a small body, past
+// that, or after a Flush, the response is committed and the data is sent
+// straight to the client, framed as chunks if no Content-Length was set.
+func (r *Response) Write(p []byte) (int, error) {
+	if !r.committed {
+		r.buf.Write(p)
+		r.moveBody()
+		if r.buf.Len() > config.BufferSize {
+			if err := r.send(); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+
+	if len(p) == 0 {
+		return 0, nil // A zero sized chunk would end the body
+	}
+
+	if r.omitBody || !bodyAllowedForStatus(r.StatusCode) {
+		return len(p), nil
+	}
+
+	return r.sink.Write(p)
+}
+
+// Flush commits the status line and headers if that has not happened yet,
+// then sends any buffered body. Without a Content-Length header the response
+// switches to "Transfer-Encoding: chunked".
+func (r *Response) Flush() error {
+	if err := r.send(); err != nil {
+		return err
+	}
+	return r.sink.Flush()
+}
+
+// send commits the response if needed and hands the buffered body to the sink.
+func (r *Response) send() error {
+	if !r.committed {
+		if err := r.commit(); err != nil {
+			return err
+		}
+	}
+
+	r.moveBody()
+	if r.buf.Len() > 0 {
+		_, err := r.Write(r.buf.Bytes())
+		r.buf.Reset()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveBody puts Body in front of the written data, so both go out in order.
+func (r *Response) moveBody() {
+	if r.Body == "" {
+		return
 	}
+	written := r.buf.Bytes()
+	var buf bytes.Buffer
+	buf.Grow(len(r.Body) + len(written))
+	buf.WriteString(r.Body)
+	buf.Write(written)
+	r.buf = buf
+	r.Body = ""
+}
+
+// BufferedBody returns the body not sent yet: Body followed by the data
+// passed to Write.
+func (r *Response) BufferedBody() string {
+	if r.buf.Len() == 0 {
+		return r.Body
+	}
+	return r.Body + r.buf.String()
+}
+
+// SetBody replaces the body not sent yet, written data included, with body.
+func (r *Response) SetBody(body string) {
+	r.buf.Reset()
+	r.Body = body
+}
+
+// Abort gives up on a committed response that cannot be completed, e.g.
+// after a panic while streaming it. SendToClient then returns
+// ErrAbortedResponse without ending the body, so the connection is closed and
+// the client sees a truncated response rather than a complete one.
+func (r *Response) Abort() {
+	r.aborted = true
+}
+
+// Committed reports whether the status line and headers were already sent.
+func (r *Response) Committed() bool {
+	return r.committed
+}
 
-	body := r.Body
+func (r *Response) SendToClient(request *Request) error {
+	if r.aborted {
+		return ErrAbortedResponse
+	}
 
-	rep := "HTTP/1.1 " + strconv.Itoa(r.StatusCode) + " " + statusMessage + config.CRLF
-	for k, v := range r.Headers {
-		rep = rep + k + ":" + v + config.CRLF
+	if !r.committed {
+		if !r.Headers.Has("Content-Length") && bodyAllowedForStatus(r.StatusCode) {
+			r.Headers.Set("Content-Length", strconv.Itoa(len(r.Body)+r.buf.Len()))
+		}
 	}
 
-	rep = rep + config.CRLF + body
+	err := r.send()
+	if err == nil {
+		err = r.sink.Close()
+	}
 
-	_, err := r.Connection.Write([]byte(rep))
 	if err != nil {
 		fmt.Println("Error writing http response to client ", err)
 		return err
@@ -61,3 +206,101 @@ func (r *Response) SendToClient(request *Request) error {
 
 	return nil
 }
+
+// commit sends the status line and headers to the sink. It never fails because
+// of the status: a missing one defaults to 200, an invalid one is replaced by
+// 500 and unknown codes get an empty reason phrase, which HTTP/1.1 allows.
+func (r *Response) commit() error {
+	if r.sink == nil {
+		r.sink = &connSink{conn: r.Connection, omitBody: r.omitBody}
+	}
+
+	if r.StatusCode == 0 {
+		r.StatusCode = 200
+	}
+	if r.StatusCode < 100 || r.StatusCode > 999 {
+		fmt.Println("Invalid status code, sending 500 instead: ", r.StatusCode)
+		r.StatusCode = 500
+		r.StatusMessage = ""
+	}
+
+	statusMessage := r.StatusMessage
+	if statusMessage == "" {
+		statusMessage = StatusText(r.StatusCode)
+	}
+
+	if !bodyAllowedForStatus(r.StatusCode) {
+		r.Headers.Del("Content-Length")
+	}
+
+	r.committed = true
+	return r.sink.WriteHeader(r.StatusCode, statusMessage, r.Headers)
+}
+
+// connSink writes a response as HTTP/1.1 to a connection. The status line and
+// headers are held until the first body write so small responses go out in a
+// single write.
+type connSink struct {
+	conn     net.Conn
+	omitBody bool
+	pending  string
+	chunked  bool
+	err      error // first write error, returned by every later call
+}
+
+func (s *connSink) WriteHeader(statusCode int, statusMessage string, headers Header) error {
+	s.chunked = !headers.Has("Content-Length") && bodyAllowedForStatus(statusCode)
+
+	// Every value of a repeated field, like Set-Cookie, goes on its own line
+	rep := "HTTP/1.1 " + strconv.Itoa(statusCode) + " " + statusMessage + config.CRLF
+	for k, values := range headers {
+		for _, v := range values {
+			rep = rep + k + ":" + v + config.CRLF
+		}
+	}
+	if s.chunked && !headers.Has("Transfer-Encoding") {
+		rep = rep + "Transfer-Encoding:chunked" + config.CRLF
+	}
+
+	s.pending = rep + config.CRLF
+	return nil
+}
+
+func (s *connSink) Write(p []byte) (int, error) {
+	data := string(p)
+	if s.chunked {
+		data = strconv.FormatInt(int64(len(p)), 16) + config.CRLF + data + config.CRLF
+	}
+
+	if err := s.send(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *connSink) Flush() error {
+	return s.send("")
+}
+
+// Close writes the last chunk, without trailers, of a chunked body.
+func (s *connSink) Close() error {
+	if !s.chunked || s.omitBody {
+		return s.send("")
+	}
+	return s.send("0" + config.CRLF + config.CRLF)
+}
+
+func (s *connSink) send(data string) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	data = s.pending + data
+	s.pending = ""
+	if data == "" {
+		return nil
+	}
+
+	_, s.err = s.conn.Write([]byte(data))
+	return s.err
+}
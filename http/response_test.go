@@ -1,8 +1,13 @@
 package http
 
 import (
+	"bytes"
+	"errors"
 	"net"
+	"strings"
 	"testing"
+
+	"github.com/codecrafters-io/http-server-starter-go/config"
 )
 
 type dummyConn struct{ net.Conn }
@@ -11,20 +16,240 @@ func (d *dummyConn) Write(b []byte) (int, error) { return len(b), nil }
 
 func TestNewResponse_ConnectionCloseHeader(t *testing.T) {
 	req := &Request{
-		Headers:    map[string]string{"Connection": "close"},
+		Headers:    Header{"Connection": {"close"}},
 		Connection: &dummyConn{},
 	}
 	resp := NewResponse(req)
-	if resp.Headers["Connection"] != "close" {
+	if resp.Headers.Get("Connection") != "close" {
 		t.Error("Expected Connection header to be 'close'")
 	}
 }
 
 func TestNewResponse_ConnectionAssignment(t *testing.T) {
 	dc := &dummyConn{}
-	req := &Request{Headers: map[string]string{}, Connection: dc}
+	req := &Request{Headers: Header{}, Connection: dc}
 	resp := NewResponse(req)
 	if resp.Connection != dc {
 		t.Error("Expected response.Connection to match request.Connection")
 	}
 }
+
+// recordingConn captures everything written to it.
+type recordingConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) { return c.written.Write(b) }
+
+func TestSendToClient_BufferedBody(t *testing.T) {
+	conn := &recordingConn{}
+	req := &Request{Headers: Header{}, Connection: conn}
+	resp := NewResponse(req)
+
+	resp.WriteHeader(200)
+	resp.Write([]byte("hello "))
+	resp.Write([]byte("world"))
+
+	if resp.BufferedBody() != "hello world" {
+		t.Errorf("Expected buffered body 'hello world', got '%s'", resp.BufferedBody())
+	}
+
+	if err := resp.SendToClient(req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	out := conn.written.String()
+	if !strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n") {
+		t.Errorf("Unexpected status line in %q", out)
+	}
+	if !strings.Contains(out, "Content-Length:11\r\n") {
+		t.Errorf("Expected computed Content-Length in %q", out)
+	}
+	if !strings.HasSuffix(out, "\r\n\r\nhello world") {
+		t.Errorf("Expected body at the end of %q", out)
+	}
+}
+
+func TestWrite_StreamsPastBufferSize(t *testing.T) {
+	conn := &recordingConn{}
+	req := &Request{Headers: Header{}, Connection: conn}
+	resp := NewResponse(req)
+
+	resp.Body = "head "
+	resp.Write([]byte("small"))
+	if resp.Committed() || resp.BufferedBody() != "head small" {
+		t.Fatalf("Expected a small body to stay buffered, got %q", resp.BufferedBody())
+	}
+
+	piece := strings.Repeat("x", 1024)
+	for range 64 {
+		resp.Write([]byte(piece))
+	}
+	if !resp.Committed() {
+		t.Fatal("Expected the response to be committed past config.BufferSize")
+	}
+	if len(resp.BufferedBody()) > config.BufferSize {
+		t.Errorf("Expected at most %d bytes buffered, got %d", config.BufferSize, len(resp.BufferedBody()))
+	}
+
+	if err := resp.SendToClient(req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	out := conn.written.String()
+	if !strings.Contains(out, "Transfer-Encoding:chunked\r\n") || !strings.HasSuffix(out, "0\r\n\r\n") {
+		t.Errorf("Expected a chunked response, got %q", out[:min(len(out), 200)])
+	}
+	if strings.Count(out, "x") != 64*1024 || !strings.Contains(out, "head small") {
+		t.Error("Expected the whole body to be sent in order")
+	}
+}
+
+func TestFlush_ChunkedWithoutContentLength(t *testing.T) {
+	conn := &recordingConn{}
+	req := &Request{Headers: Header{}, Connection: conn}
+	resp := NewResponse(req)
+
+	resp.WriteHeader(200)
+	resp.Write([]byte("first"))
+	if err := resp.Flush(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !resp.Committed() {
+		t.Error("Expected response to be committed after Flush")
+	}
+
+	// Status can no longer change once committed
+	resp.WriteHeader(404)
+	resp.Write([]byte("second chunk"))
+	if err := resp.SendToClient(req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	out := conn.written.String()
+	if !strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n") {
+		t.Errorf("Unexpected status line in %q", out)
+	}
+	if !strings.Contains(out, "Transfer-Encoding:chunked\r\n") {
+		t.Errorf("Expected chunked Transfer-Encoding in %q", out)
+	}
+	if !strings.HasSuffix(out, "\r\n\r\n5\r\nfirst\r\nc\r\nsecond chunk\r\n0\r\n\r\n") {
+		t.Errorf("Unexpected chunked body in %q", out)
+	}
+}
+
+func TestSendToClient_AbortedStreamIsNotEnded(t *testing.T) {
+	conn := &recordingConn{}
+	req := &Request{Headers: Header{}, Connection: conn}
+	resp := NewResponse(req)
+
+	resp.Write([]byte("first"))
+	if err := resp.Flush(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Abort()
+
+	if err := resp.SendToClient(req); !errors.Is(err, ErrAbortedResponse) {
+		t.Fatalf("Expected ErrAbortedResponse, got %v", err)
+	}
+	if out := conn.written.String(); !strings.HasSuffix(out, "5\r\nfirst\r\n") {
+		t.Errorf("Expected the chunked body to be left open in %q", out)
+	}
+}
+
+func TestFlush_StreamWithContentLength(t *testing.T) {
+	conn := &recordingConn{}
+	req := &Request{Headers: Header{}, Connection: conn}
+	resp := NewResponse(req)
+
+	resp.WriteHeader(200)
+	resp.Headers.Set("Content-Length", "10")
+	if err := resp.Flush(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Write([]byte("01234"))
+	resp.Write([]byte("56789"))
+	if err := resp.SendToClient(req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	out := conn.written.String()
+	if strings.Contains(out, "Transfer-Encoding") {
+		t.Errorf("Did not expect Transfer-Encoding with a known length in %q", out)
+	}
+	if !strings.HasSuffix(out, "\r\n\r\n0123456789") {
+		t.Errorf("Expected raw body at the end of %q", out)
+	}
+}
+
+func TestSendToClient_StatusLines(t *testing.T) {
+	tests := []struct {
+		name          string
+		statusCode    int
+		statusMessage string
+		expected      string
+	}{
+		{"Method not allowed", 405, "", "HTTP/1.1 405 Method Not Allowed\r\n"},
+		{"Internal server error", 500, "", "HTTP/1.1 500 Internal Server Error\r\n"},
+		{"Teapot", 418, "", "HTTP/1.1 418 I'm a teapot\r\n"},
+		{"Custom reason", 200, "Everything Fine", "HTTP/1.1 200 Everything Fine\r\n"},
+		{"Unknown code", 299, "", "HTTP/1.1 299 \r\n"},
+		{"Unset code", 0, "", "HTTP/1.1 200 OK\r\n"},
+		{"Invalid code", 42, "Nope", "HTTP/1.1 500 Internal Server Error\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &recordingConn{}
+			req := &Request{Headers: Header{}, Connection: conn}
+			resp := NewResponse(req)
+			resp.StatusCode = tt.statusCode
+			resp.StatusMessage = tt.statusMessage
+
+			if err := resp.SendToClient(req); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if out := conn.written.String(); !strings.HasPrefix(out, tt.expected) {
+				t.Errorf("Expected status line %q, got %q", tt.expected, out)
+			}
+		})
+	}
+}
+
+func TestSendToClient_NoContentHasNoBody(t *testing.T) {
+	conn := &recordingConn{}
+	req := &Request{Headers: Header{}, Connection: conn}
+	resp := NewResponse(req)
+	resp.StatusCode = 204
+	resp.Body = "ignored"
+
+	if err := resp.SendToClient(req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	out := conn.written.String()
+	if out != "HTTP/1.1 204 No Content\r\n\r\n" {
+		t.Errorf("Expected bare 204 response, got %q", out)
+	}
+}
+
+func TestSendToClient_HeadOmitsBody(t *testing.T) {
+	conn := &recordingConn{}
+	req := &Request{Method: "HEAD", Headers: Header{}, Connection: conn}
+	resp := NewResponse(req)
+	resp.StatusCode = 200
+	resp.Body = "hello"
+
+	if err := resp.SendToClient(req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	out := conn.written.String()
+	if !strings.Contains(out, "Content-Length:5\r\n") {
+		t.Errorf("Expected Content-Length of the GET body in %q", out)
+	}
+	if !strings.HasSuffix(out, "\r\n\r\n") {
+		t.Errorf("Expected no body in HEAD response %q", out)
+	}
+}
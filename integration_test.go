@@ -1,23 +1,45 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/codecrafters-io/http-server-starter-go/config"
 	"github.com/codecrafters-io/http-server-starter-go/handler"
+	httpPkg "github.com/codecrafters-io/http-server-starter-go/http"
+	"github.com/codecrafters-io/http-server-starter-go/middleware"
 	"github.com/codecrafters-io/http-server-starter-go/server"
 )
 
 // Integration test helper functions
 func setupTestServer(t *testing.T) (*server.Server, string) {
+	return setupTestServerWithConfig(t, nil)
+}
+
+// setupTestServerWithConfig lets configure change the default test config
+// before the server starts.
+func setupTestServerWithConfig(t *testing.T, configure func(cfg *config.Config)) (*server.Server, string) {
 	// Create temporary directory for file tests
 	tempDir, err := os.MkdirTemp("", "http_server_test_")
 	if err != nil {
@@ -26,27 +48,22 @@ func setupTestServer(t *testing.T) (*server.Server, string) {
 
 	cfg := config.DefaultConfig()
 	cfg.Port = "0"
+	cfg.TLSPort = "0"
 	cfg.FileDir = tempDir
+	if configure != nil {
+		configure(cfg)
+	}
 
 	srv, err := server.NewServer(cfg)
 	if err != nil {
 		t.Fatalf("Failed to create server: %v", err)
 	}
 
-	go srv.Start()
-
-	// Wait for server to start and get the actual port
-	// Keep checking until we get a valid port
-	maxRetries := 50 // 5 seconds total
-	for i := 0; i < maxRetries; i++ {
-		time.Sleep(100 * time.Millisecond)
-		if srv.Port != "0" && srv.Port != "" {
-			break
-		}
-		if i == maxRetries-1 {
-			t.Fatalf("Server failed to start within timeout")
-		}
+	// The ports are known once listening
+	if err := srv.Listen(); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
 	}
+	go srv.Start()
 
 	return srv, tempDir
 }
@@ -139,6 +156,9 @@ func TestIntegration_EchoEndpoint(t *testing.T) {
 		{"Echo with special chars", "/echo/hello@world.com", "hello@world.com"},
 		{"Empty echo", "/echo/", ""},
 		{"Echo with numbers", "/echo/12345", "12345"},
+		{"Echo percent-decoded", "/echo/hello%20world", "hello world"},
+		{"Echo without query", "/echo/hello?x=1&x=2", "hello"},
+		{"Echo with malformed query", "/echo/hello?x=1;y=2", "hello"},
 	}
 
 	for _, tt := range tests {
@@ -287,6 +307,18 @@ func TestIntegration_FileOperations(t *testing.T) {
 	if resp.StatusCode != http.StatusNotFound {
 		t.Errorf("Expected status 404 for non-existent file, got %d", resp.StatusCode)
 	}
+	// Encoded separators must not escape the files directory
+	for _, path := range []string{"/files/..%2Fsecret.txt", "/files/%2E%2E", "/files/a%5C..%5Cb"} {
+		resp, err = makeHTTPRequest("GET", baseURL+path, "", nil)
+		if err != nil {
+			t.Fatalf("Failed to request %s: %v", path, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected with 404 or 400, got %d", path, resp.StatusCode)
+		}
+	}
 }
 
 // Test concurrent requests
@@ -372,8 +404,8 @@ func TestIntegration_HTTPMethods(t *testing.T) {
 		{"GET user-agent", "GET", "/user-agent", http.StatusOK},
 		{"GET files", "GET", "/files/nonexistent.txt", http.StatusNotFound},
 		{"POST files", "POST", "/files/test.txt", http.StatusCreated},
-		// {"PUT files (not implemented)", "PUT", "/files/test.txt", http.StatusMethodNotAllowed},
-		// {"DELETE files (not implemented)", "DELETE", "/files/test.txt", http.StatusMethodNotAllowed},
+		{"PUT files (not implemented)", "PUT", "/files/test.txt", http.StatusMethodNotAllowed},
+		{"DELETE files (not implemented)", "DELETE", "/files/test.txt", http.StatusMethodNotAllowed},
 	}
 
 	for _, tt := range tests {
@@ -505,48 +537,1458 @@ func TestIntegration_ConnectionHandling(t *testing.T) {
 	}
 }
 
-// TODO : Handle this test
 // Test large request handling
-// func TestIntegration_LargeRequests(t *testing.T) {
-// 	srv, tempDir := setupTestServer(t)
-// 	defer cleanup(srv, tempDir)
-
-// 	baseURL := fmt.Sprintf("http://localhost:%s", srv.Port)
-
-// 	// Test large echo request
-// 	largeText := strings.Repeat("A", 500)
-// 	resp, err := makeHTTPRequest("GET", baseURL+"/echo/"+largeText, "", nil)
-// 	if err != nil {
-// 		t.Fatalf("Failed to make large echo request: %v", err)
-// 	}
-// 	defer resp.Body.Close()
-
-// 	if resp.StatusCode != http.StatusOK {
-// 		t.Errorf("Expected status 200, got %d", resp.StatusCode)
-// 	}
-
-// 	body, err := io.ReadAll(resp.Body)
-// 	if err != nil {
-// 		t.Fatalf("Failed to read response body: %v", err)
-// 	}
-
-// 	if string(body) != largeText {
-// 		t.Errorf("Large echo response mismatch")
-// 	}
-
-// 	// Test large file upload
-// 	largeContent := strings.Repeat("Hello World! ", 1000) // ~12KB
-// 	headers := map[string]string{
-// 		"Content-Type": "application/octet-stream",
-// 	}
-
-// 	resp, err = makeHTTPRequest("POST", baseURL+"/files/large.txt", largeContent, headers)
-// 	if err != nil {
-// 		t.Fatalf("Failed to upload large file: %v", err)
-// 	}
-// 	defer resp.Body.Close()
-
-// 	if resp.StatusCode != http.StatusCreated {
-// 		t.Errorf("Expected status 201 for large file upload, got %d", resp.StatusCode)
-// 	}
-// }
+func TestIntegration_LargeRequests(t *testing.T) {
+	srv, tempDir := setupTestServer(t)
+	defer cleanup(srv, tempDir)
+
+	baseURL := fmt.Sprintf("http://localhost:%s", srv.Port)
+
+	// Test large echo request
+	largeText := strings.Repeat("A", 500)
+	resp, err := makeHTTPRequest("GET", baseURL+"/echo/"+largeText, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to make large echo request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+
+	if string(body) != largeText {
+		t.Errorf("Large echo response mismatch")
+	}
+
+	// Test large file upload
+	largeContent := strings.Repeat("Hello World! ", 1000) // ~12KB
+	headers := map[string]string{
+		"Content-Type": "application/octet-stream",
+	}
+
+	resp, err = makeHTTPRequest("POST", baseURL+"/files/large.txt", largeContent, headers)
+	if err != nil {
+		t.Fatalf("Failed to upload large file: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected status 201 for large file upload, got %d", resp.StatusCode)
+	}
+}
+
+// Test multi-megabyte upload over the TLS listener
+func TestIntegration_LargeUploadTLS(t *testing.T) {
+	srv, tempDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.DevTLS = true
+	})
+	defer cleanup(srv, tempDir)
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+
+	largeContent := strings.Repeat("0123456789abcdef", 256*1024) // 4MB
+	req, err := http.NewRequest("POST", fmt.Sprintf("https://localhost:%s/files/large-tls.bin", srv.TLSPort), strings.NewReader(largeContent))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("Connection", "close")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to upload large file over TLS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201 for large TLS upload, got %d", resp.StatusCode)
+	}
+
+	savedContent, err := os.ReadFile(filepath.Join(tempDir, "large-tls.bin"))
+	if err != nil {
+		t.Fatalf("Failed to read saved file: %v", err)
+	}
+	if string(savedContent) != largeContent {
+		t.Errorf("Saved content mismatch, expected %d bytes, got %d", len(largeContent), len(savedContent))
+	}
+}
+
+// Test upload of unknown length sent with chunked Transfer-Encoding
+func TestIntegration_ChunkedUpload(t *testing.T) {
+	srv, tempDir := setupTestServer(t)
+	defer cleanup(srv, tempDir)
+
+	baseURL := fmt.Sprintf("http://localhost:%s", srv.Port)
+
+	content := strings.Repeat("chunked upload content ", 2000)
+	// A pipe has no known length, so the client sends the body chunked
+	pr, pw := io.Pipe()
+	go func() {
+		// Write in several pieces so the client emits several chunks
+		for i := 0; i < len(content); i += 1000 {
+			end := min(i+1000, len(content))
+			pw.Write([]byte(content[i:end]))
+		}
+		pw.Close()
+	}()
+
+	req, err := http.NewRequest("POST", baseURL+"/files/chunked.txt", pr)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("Connection", "close")
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to upload chunked file: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201 for chunked upload, got %d", resp.StatusCode)
+	}
+
+	savedContent, err := os.ReadFile(filepath.Join(tempDir, "chunked.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read saved file: %v", err)
+	}
+	if string(savedContent) != content {
+		t.Errorf("Saved content mismatch, expected %d bytes, got %d", len(content), len(savedContent))
+	}
+}
+
+// Test download of a file larger than the read buffer, which is streamed
+func TestIntegration_LargeFileDownload(t *testing.T) {
+	srv, tempDir := setupTestServer(t)
+	defer cleanup(srv, tempDir)
+
+	baseURL := fmt.Sprintf("http://localhost:%s", srv.Port)
+
+	content := strings.Repeat("streamed file content\n", 100000) // ~2MB
+	if err := os.WriteFile(filepath.Join(tempDir, "stream.txt"), []byte(content), 0666); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	resp, err := makeHTTPRequest("GET", baseURL+"/files/stream.txt", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to download file: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if resp.ContentLength != int64(len(content)) {
+		t.Errorf("Expected Content-Length %d, got %d", len(content), resp.ContentLength)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+	if string(body) != content {
+		t.Errorf("Downloaded content mismatch, expected %d bytes, got %d", len(content), len(body))
+	}
+}
+
+// Test automatic HEAD, OPTIONS and 405 responses
+func TestIntegration_MethodRouting(t *testing.T) {
+	srv, tempDir := setupTestServer(t)
+	defer cleanup(srv, tempDir)
+
+	baseURL := fmt.Sprintf("http://localhost:%s", srv.Port)
+
+	if err := os.WriteFile(filepath.Join(tempDir, "head.txt"), []byte("head content"), 0666); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	resp, err := makeHTTPRequest("HEAD", baseURL+"/files/head.txt", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to make HEAD request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 for HEAD, got %d", resp.StatusCode)
+	}
+	if resp.ContentLength != int64(len("head content")) {
+		t.Errorf("Expected Content-Length %d for HEAD, got %d", len("head content"), resp.ContentLength)
+	}
+
+	resp, err = makeHTTPRequest("OPTIONS", baseURL+"/files/head.txt", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to make OPTIONS request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status 204 for OPTIONS, got %d", resp.StatusCode)
+	}
+	if allow := resp.Header.Get("Allow"); allow != "GET, HEAD, OPTIONS, POST" {
+		t.Errorf("Expected Allow 'GET, HEAD, OPTIONS, POST', got '%s'", allow)
+	}
+
+	resp, err = makeHTTPRequest("DELETE", baseURL+"/files/head.txt", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to make DELETE request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for DELETE, got %d", resp.StatusCode)
+	}
+	if allow := resp.Header.Get("Allow"); allow != "GET, HEAD, OPTIONS, POST" {
+		t.Errorf("Expected Allow 'GET, HEAD, OPTIONS, POST', got '%s'", allow)
+	}
+}
+
+// Test several requests written in a single segment on one connection
+func TestIntegration_Pipelining(t *testing.T) {
+	srv, tempDir := setupTestServer(t)
+	defer cleanup(srv, tempDir)
+
+	conn, err := net.Dial("tcp", "localhost:"+srv.Port)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	body := "pipelined content"
+	requests := "GET /echo/first HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		fmt.Sprintf("POST /files/pipelined.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n%s", len(body), body) +
+		"GET /files/pipelined.txt HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /echo/last HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"
+	if _, err := conn.Write([]byte(requests)); err != nil {
+		t.Fatalf("Failed to write requests: %v", err)
+	}
+
+	expected := []struct {
+		status int
+		body   string
+	}{
+		{http.StatusOK, "first"},
+		{http.StatusCreated, ""},
+		{http.StatusOK, body},
+		{http.StatusOK, "last"},
+	}
+
+	reader := bufio.NewReader(conn)
+	for i, want := range expected {
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Failed to read response %d: %v", i, err)
+		}
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to read response %d body: %v", i, err)
+		}
+
+		if resp.StatusCode != want.status || string(got) != want.body {
+			t.Errorf("Response %d: expected %d '%s', got %d '%s'", i, want.status, want.body, resp.StatusCode, got)
+		}
+	}
+
+	// The last request asked to close the connection
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the connection to be closed, got %v", err)
+	}
+}
+
+// Test ambiguous request framing is rejected instead of letting a request be
+// smuggled after the body
+func TestIntegration_RequestSmuggling(t *testing.T) {
+	srv, tempDir := setupTestServer(t)
+	defer cleanup(srv, tempDir)
+
+	tests := []struct {
+		name           string
+		headers        string
+		expectedStatus int
+	}{
+		{"Content-Length and Transfer-Encoding", "Content-Length: 5\r\nTransfer-Encoding: chunked\r\n", http.StatusBadRequest},
+		{"coding before chunked", "Transfer-Encoding: gzip, chunked\r\n", http.StatusNotImplemented},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", "localhost:"+srv.Port)
+			if err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			conn.Write([]byte("POST /files/smuggling.txt HTTP/1.1\r\nHost: localhost\r\n" + tt.headers + "\r\n" +
+				"0\r\n\r\nGET /echo/smuggled HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+			reader := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+
+			if resp, err := http.ReadResponse(reader, nil); err == nil {
+				t.Errorf("Expected the connection to be closed, the smuggled request got %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+// Test that a malformed chunked body is answered with a 400 before closing
+func TestIntegration_MalformedChunkedBody(t *testing.T) {
+	srv, tempDir := setupTestServer(t)
+	defer cleanup(srv, tempDir)
+
+	conn, err := net.Dial("tcp", "localhost:"+srv.Port)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("POST /files/malformed.txt HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"zz\r\nabc\r\n0\r\n\r\n"))
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "malformed.txt")); err == nil {
+		t.Error("Expected no file to be created for a malformed body")
+	}
+}
+
+// Test uploads sent with "Expect: 100-continue", as curl does for large bodies
+func TestIntegration_ExpectContinue(t *testing.T) {
+	srv, tempDir := setupTestServer(t)
+	defer cleanup(srv, tempDir)
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", "localhost:"+srv.Port)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+
+	// Accepted upload: interim 100 Continue, then the body, then 201
+	conn, reader := dial()
+	defer conn.Close()
+
+	body := "sent after continue"
+	fmt.Fprintf(conn, "POST /files/continue.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n", len(body))
+
+	statusLine, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read interim response: %v", err)
+	}
+	if statusLine != "HTTP/1.1 100 Continue\r\n" {
+		t.Fatalf("Expected 100 Continue, got %q", statusLine)
+	}
+	if line, _ := reader.ReadString('\n'); line != "\r\n" {
+		t.Fatalf("Expected end of interim response, got %q", line)
+	}
+
+	conn.Write([]byte(body))
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", resp.StatusCode)
+	}
+
+	saved, err := os.ReadFile(filepath.Join(tempDir, "continue.txt"))
+	if err != nil || string(saved) != body {
+		t.Errorf("Expected saved file '%s', got '%s' (%v)", body, saved, err)
+	}
+
+	// Rejected upload: final response without 100 Continue, connection closed
+	conn, reader = dial()
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "POST /files/%%2E%%2E HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1000000\r\nExpect: 100-continue\r\n\r\n")
+
+	resp, err = http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 before the body is sent, got %d", resp.StatusCode)
+	}
+	if !resp.Close {
+		t.Error("Expected Connection: close when the body was not read")
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the connection to be closed, got %v", err)
+	}
+}
+
+// Test requests over the configured limits are answered instead of read
+func TestIntegration_RequestLimits(t *testing.T) {
+	srv, tempDir := setupTestServer(t)
+	defer cleanup(srv, tempDir)
+
+	tests := []struct {
+		name           string
+		request        string
+		expectedStatus int
+	}{
+		{"URI too long", "GET /echo/" + strings.Repeat("a", srv.MaxRequestLineSize) + " HTTP/1.1\r\nHost: localhost\r\n\r\n", http.StatusRequestURITooLong},
+		{"Header too large", "GET /echo/a HTTP/1.1\r\nX-Big: " + strings.Repeat("a", srv.MaxHeaderBytes) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge},
+		{"Too many headers", "GET /echo/a HTTP/1.1\r\n" + strings.Repeat("X-Header: a\r\n", srv.MaxHeaderCount+1) + "\r\n", http.StatusRequestHeaderFieldsTooLarge},
+		{"Body too large", fmt.Sprintf("POST /files/big.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n", srv.MaxBodySize+1), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", "localhost:"+srv.Port)
+			if err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			if _, err := conn.Write([]byte(tt.request)); err != nil {
+				t.Fatalf("Failed to write request: %v", err)
+			}
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+			if !resp.Close {
+				t.Error("Expected Connection: close")
+			}
+		})
+	}
+}
+
+// Test clients sending or reading too slowly are disconnected
+func TestIntegration_Timeouts(t *testing.T) {
+	srv, tempDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.ReadHeaderTimeout = 300 * time.Millisecond
+		cfg.ReadBodyTimeout = 300 * time.Millisecond
+		cfg.WriteTimeout = 300 * time.Millisecond
+		cfg.IdleTimeout = 300 * time.Millisecond
+	})
+	defer cleanup(srv, tempDir)
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", "localhost:"+srv.Port)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+
+	// expectStatus reads a response and checks the connection is closed after it
+	expectStatus := func(conn net.Conn, expected int) {
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("Expected status %d, got %d", expected, resp.StatusCode)
+		}
+	}
+
+	t.Run("Slow headers", func(t *testing.T) {
+		conn := dial()
+		defer conn.Close()
+
+		// Trickle one header line every 100ms, the server must not wait for the end
+		conn.Write([]byte("GET /echo/slow HTTP/1.1\r\n"))
+		for i := 0; i < 10; i++ {
+			time.Sleep(100 * time.Millisecond)
+			if _, err := fmt.Fprintf(conn, "X-Slow-%d: a\r\n", i); err != nil {
+				break
+			}
+		}
+		expectStatus(conn, http.StatusRequestTimeout)
+	})
+
+	t.Run("Slow body", func(t *testing.T) {
+		conn := dial()
+		defer conn.Close()
+
+		conn.Write([]byte("POST /files/slow.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabc"))
+		expectStatus(conn, http.StatusRequestTimeout)
+	})
+
+	t.Run("Idle keep-alive", func(t *testing.T) {
+		conn := dial()
+		defer conn.Close()
+
+		conn.Write([]byte("GET /echo/idle HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		resp.Body.Close()
+
+		// Nothing else is sent, the server closes the connection
+		start := time.Now()
+		if _, err := reader.ReadByte(); err != io.EOF {
+			t.Errorf("Expected the idle connection to be closed, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Idle connection closed after %v", elapsed)
+		}
+	})
+
+	t.Run("Slow reader", func(t *testing.T) {
+		// Large enough not to fit in the socket buffers
+		content := strings.Repeat("x", 16*1024*1024)
+		if err := os.WriteFile(filepath.Join(tempDir, "large.bin"), []byte(content), 0666); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+
+		conn := dial()
+		defer conn.Close()
+		conn.Write([]byte("GET /files/large.bin HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+		// Never read the response, the server gives up writing
+		deadline := time.Now().Add(3 * time.Second)
+		for srv.GetTimeouts().Write == 0 && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+
+	timeouts := srv.GetTimeouts()
+	if timeouts.Header != 1 || timeouts.Body != 1 || timeouts.Idle != 1 || timeouts.Write != 1 {
+		t.Errorf("Expected one timeout of each kind, got %+v", timeouts)
+	}
+}
+
+// Test clients over the configured capacity get a 503 instead of a closed socket
+func TestIntegration_ConcurrencyModes(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *config.Config)
+		capacity  int
+	}{
+		{"Elastic pool", func(cfg *config.Config) {
+			cfg.Concurrency = config.ConcurrencyPool
+			cfg.MinWorkers = 1
+			cfg.MaxWorkers = 3
+			cfg.QueueSize = 0
+		}, 3},
+		{"Goroutine per connection", func(cfg *config.Config) {
+			cfg.Concurrency = config.ConcurrencyPerConnection
+			cfg.MaxConnections = 3
+		}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, tempDir := setupTestServerWithConfig(t, tt.configure)
+			defer cleanup(srv, tempDir)
+
+			// request sends a keep-alive request on conn and returns the status
+			request := func(conn net.Conn, reader *bufio.Reader) int {
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				conn.Write([]byte("GET /echo/hi HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+				resp, err := http.ReadResponse(reader, nil)
+				if err != nil {
+					t.Fatalf("Failed to read response: %v", err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+
+			// Idle keep-alive clients hold the whole capacity
+			var conns []net.Conn
+			for i := 0; i < tt.capacity; i++ {
+				conn, err := net.Dial("tcp", "localhost:"+srv.Port)
+				if err != nil {
+					t.Fatalf("Failed to connect: %v", err)
+				}
+				defer conn.Close()
+				conns = append(conns, conn)
+
+				if status := request(conn, bufio.NewReader(conn)); status != http.StatusOK {
+					t.Fatalf("Expected client %d to be served, got %d", i, status)
+				}
+			}
+
+			conn, err := net.Dial("tcp", "localhost:"+srv.Port)
+			if err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			if status := request(conn, bufio.NewReader(conn)); status != http.StatusServiceUnavailable {
+				t.Errorf("Expected status 503 over capacity, got %d", status)
+			}
+			conn.Close()
+
+			// Once a client leaves, a new one is served
+			conns[0].Close()
+			time.Sleep(100 * time.Millisecond)
+
+			conn, err = net.Dial("tcp", "localhost:"+srv.Port)
+			if err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer conn.Close()
+			if status := request(conn, bufio.NewReader(conn)); status != http.StatusOK {
+				t.Errorf("Expected status 200 after a client left, got %d", status)
+			}
+		})
+	}
+}
+
+// Test a flood of connections over capacity does not start a goroutine for
+// each of them
+func TestIntegration_RejectionFlood(t *testing.T) {
+	srv, tempDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.Concurrency = config.ConcurrencyPool
+		cfg.MinWorkers = 0
+		cfg.MaxWorkers = 1
+		cfg.QueueSize = 0
+	})
+	defer cleanup(srv, tempDir)
+
+	// An idle keep-alive client holds the only worker, started for it
+	busy, err := net.Dial("tcp", "localhost:"+srv.Port)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer busy.Close()
+	busy.SetDeadline(time.Now().Add(5 * time.Second))
+	busy.Write([]byte("GET /echo/hi HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(busy), nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	// The rejected clients never close, each rejection waits for them
+	before := runtime.NumGoroutine()
+	var conns []net.Conn
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", "localhost:"+srv.Port)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if started := runtime.NumGoroutine() - before; started > 32 {
+		t.Errorf("Expected the rejections to be bounded, %d goroutines started", started)
+	}
+
+	// The first ones are still answered
+	conns[0].SetDeadline(time.Now().Add(5 * time.Second))
+	resp, err = http.ReadResponse(bufio.NewReader(conns[0]), nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", resp.StatusCode)
+	}
+}
+
+// Test shutdown closes idle connections, drains in-flight requests and drops
+// the ones still running at the drain deadline
+func TestIntegration_GracefulDrain(t *testing.T) {
+	srv, tempDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.DrainTimeout = 500 * time.Millisecond
+	})
+	defer os.RemoveAll(tempDir)
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", "localhost:"+srv.Port)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+
+	// Idle keep-alive connection after one request
+	idle, idleReader := dial()
+	defer idle.Close()
+	idle.Write([]byte("GET /echo/idle HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	resp, err := http.ReadResponse(idleReader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	resp.Body.Close()
+
+	// In-flight upload, finished during the drain
+	inFlight, inFlightReader := dial()
+	defer inFlight.Close()
+	inFlight.Write([]byte("POST /files/drain.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhello"))
+
+	// Upload never finished
+	stuck, stuckReader := dial()
+	defer stuck.Close()
+	stuck.Write([]byte("POST /files/stuck.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhello"))
+
+	time.Sleep(100 * time.Millisecond)
+	reports := make(chan server.ShutdownReport)
+	go func() { reports <- srv.ShutDown() }()
+
+	// The idle connection is closed right away
+	if _, err := idleReader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the idle connection to be closed, got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	inFlight.Write([]byte("world"))
+	resp, err = http.ReadResponse(inFlightReader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read in-flight response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || !resp.Close {
+		t.Errorf("Expected 201 with Connection: close, got %d (close %v)", resp.StatusCode, resp.Close)
+	}
+
+	report := <-reports
+	if report.Idle != 1 || report.Drained != 1 || report.Dropped != 1 {
+		t.Errorf("Expected 1 idle, 1 drained and 1 dropped connection, got %+v", report)
+	}
+	if _, err := stuckReader.ReadByte(); err == nil {
+		t.Error("Expected the stuck connection to be closed")
+	}
+}
+
+// Test a connection still queued for a worker at shutdown does not hold the
+// drain until its deadline
+func TestIntegration_GracefulDrainQueued(t *testing.T) {
+	srv, tempDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.Concurrency = config.ConcurrencyPool
+		cfg.MinWorkers = 1
+		cfg.MaxWorkers = 1
+		cfg.QueueSize = 1
+		cfg.DrainTimeout = 2 * time.Second
+	})
+	defer os.RemoveAll(tempDir)
+
+	// The only worker serves an upload, the next connection waits in the queue
+	inFlight, err := net.Dial("tcp", "localhost:"+srv.Port)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer inFlight.Close()
+	inFlight.SetDeadline(time.Now().Add(5 * time.Second))
+	inFlight.Write([]byte("POST /files/drain.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhello"))
+	time.Sleep(100 * time.Millisecond)
+
+	queued, err := net.Dial("tcp", "localhost:"+srv.Port)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer queued.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	reports := make(chan server.ShutdownReport)
+	go func() { reports <- srv.ShutDown() }()
+
+	time.Sleep(100 * time.Millisecond)
+	inFlight.Write([]byte("world"))
+	resp, err := http.ReadResponse(bufio.NewReader(inFlight), nil)
+	if err != nil {
+		t.Fatalf("Failed to read in-flight response: %v", err)
+	}
+	resp.Body.Close()
+
+	report := <-reports
+	if report.Idle != 1 || report.Drained != 1 || report.Dropped != 0 {
+		t.Errorf("Expected 1 idle and 1 drained connection, got %+v", report)
+	}
+	if duration := time.Since(start); duration > time.Second {
+		t.Errorf("Expected the drain to end with the upload, took %v", duration)
+	}
+}
+
+// Test request contexts are cancelled on client disconnect, request deadline
+// and server shutdown
+func TestIntegration_RequestCancellation(t *testing.T) {
+	srv, tempDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.DrainTimeout = 200 * time.Millisecond
+	})
+	defer os.RemoveAll(tempDir)
+
+	causes := make(chan error, 1)
+	srv.Router().Get("/wait", handler.HandlerFunc(func(req *httpPkg.Request, resp *httpPkg.Response) {
+		<-req.Context().Done()
+		causes <- context.Cause(req.Context())
+	}))
+	srv.Router().Get("/wait/short", handler.HandlerFunc(func(req *httpPkg.Request, resp *httpPkg.Response) {
+		<-req.Context().Done()
+		causes <- context.Cause(req.Context())
+	}), middleware.TimeoutMiddleware(100*time.Millisecond))
+
+	expectCause := func(expected error) {
+		t.Helper()
+		select {
+		case cause := <-causes:
+			if cause != expected {
+				t.Errorf("Expected cause %v, got %v", expected, cause)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("Expected the request context to be cancelled with %v", expected)
+		}
+	}
+
+	send := func(path string) net.Conn {
+		conn, err := net.Dial("tcp", "localhost:"+srv.Port)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		return conn
+	}
+
+	// The client goes away while the handler runs
+	conn := send("/wait")
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+	expectCause(httpPkg.ErrClientDisconnected)
+
+	// The route deadline expires
+	conn = send("/wait/short")
+	defer conn.Close()
+	expectCause(context.DeadlineExceeded)
+
+	// The server shuts down and the drain deadline expires
+	conn = send("/wait")
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	srv.ShutDown()
+	expectCause(httpPkg.ErrServerShutdown)
+}
+
+// Test requests get an ID, kept from the client when it sends one
+func TestIntegration_RequestID(t *testing.T) {
+	srv, tempDir := setupTestServer(t)
+	defer cleanup(srv, tempDir)
+
+	baseURL := fmt.Sprintf("http://localhost:%s", srv.Port)
+
+	resp, err := makeHTTPRequest("GET", baseURL+"/echo/id", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	if len(resp.Header.Get("X-Request-Id")) != 16 {
+		t.Errorf("Expected a generated request ID, got '%s'", resp.Header.Get("X-Request-Id"))
+	}
+
+	resp, err = makeHTTPRequest("GET", baseURL+"/echo/id", "", map[string]string{"X-Request-Id": "client-42"})
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Request-Id") != "client-42" {
+		t.Errorf("Expected the client request ID to be kept, got '%s'", resp.Header.Get("X-Request-Id"))
+	}
+}
+
+// Test a panicking handler gets a 500 without killing the worker serving it
+func TestIntegration_PanicRecovery(t *testing.T) {
+	srv, tempDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.MinWorkers = 1
+		cfg.MaxWorkers = 1
+	})
+	defer cleanup(srv, tempDir)
+
+	srv.Router().Get("/panic", handler.HandlerFunc(func(req *httpPkg.Request, resp *httpPkg.Response) {
+		panic("handler failure")
+	}))
+	srv.Router().Get("/panic/stream", handler.HandlerFunc(func(req *httpPkg.Request, resp *httpPkg.Response) {
+		resp.Write([]byte("partial"))
+		resp.Flush()
+		panic("stream failure")
+	}))
+
+	baseURL := fmt.Sprintf("http://localhost:%s", srv.Port)
+
+	// More panics than workers, the pool must still serve afterwards
+	for i := 0; i < 3; i++ {
+		resp, err := makeHTTPRequest("GET", baseURL+"/panic", "", nil)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("Expected status 500, got %d", resp.StatusCode)
+		}
+	}
+
+	// A response already streamed is cut short instead
+	resp, err := makeHTTPRequest("GET", baseURL+"/panic/stream", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Error("Expected the streamed response to be truncated")
+	}
+	resp.Body.Close()
+
+	resp, err = makeHTTPRequest("GET", baseURL+"/health", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to make health request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var health handler.HealthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatalf("Failed to decode health response: %v", err)
+	}
+	if health.Panics != 4 {
+		t.Errorf("Expected 4 panics, got %d", health.Panics)
+	}
+}
+
+// Test a file that cannot be sent in full is not answered as a complete one
+func TestIntegration_FileSendFailure(t *testing.T) {
+	srv, tempDir := setupTestServer(t)
+	defer cleanup(srv, tempDir)
+
+	// The request expires before the file is read
+	fileHandler := handler.NewFileHandler(tempDir)
+	srv.Router().Get("/expired/{name}", handler.HandlerFunc(fileHandler.Read), middleware.TimeoutMiddleware(time.Nanosecond))
+
+	if err := os.WriteFile(filepath.Join(tempDir, "small.txt"), []byte("small file"), 0644); err != nil {
+		t.Fatalf("Failed to create small file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "large.txt"), []byte(strings.Repeat("x", 4*config.BufferSize)), 0644); err != nil {
+		t.Fatalf("Failed to create large file: %v", err)
+	}
+
+	baseURL := fmt.Sprintf("http://localhost:%s", srv.Port)
+
+	// Still buffered, the response is replaced by a 500
+	resp, err := makeHTTPRequest("GET", baseURL+"/expired/small.txt", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || len(body) != 0 {
+		t.Errorf("Expected an empty 500, got %d %q", resp.StatusCode, body)
+	}
+
+	// Already streaming, the connection is closed before the announced length
+	resp, err = makeHTTPRequest("GET", baseURL+"/expired/large.txt", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Error("Expected the streamed file to be truncated")
+	}
+	resp.Body.Close()
+}
+
+// testCert is a certificate generated for the TLS tests, signed by issuer
+// or self-signed when issuer is nil.
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPem []byte // the certificate followed by the chain up to the root
+	keyPem  []byte
+}
+
+func newTestCert(t *testing.T, commonName string, dnsNames []string, notAfter time.Time, issuer *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if dnsNames == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	}
+
+	parent, parentKey := template, key
+	if issuer != nil {
+		parent, parentKey = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if issuer != nil {
+		certPem = append(certPem, issuer.certPem...)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPem: certPem,
+		keyPem:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func newTestCA(t *testing.T, commonName string) *testCert {
+	return newTestCert(t, commonName, nil, time.Now().Add(24*time.Hour), nil)
+}
+
+// writeFiles writes the certificate and key PEM files in dir.
+func (c *testCert) writeFiles(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, c.certPem, 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, c.keyPem, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+// Test the TLS listener serves a certificate chain loaded from files
+func TestIntegration_TLSCertificateFiles(t *testing.T) {
+	certDir := t.TempDir()
+	root := newTestCA(t, "Test Root")
+	intermediate := newTestCert(t, "Test Intermediate", nil, time.Now().Add(24*time.Hour), root)
+	leaf := newTestCert(t, "localhost", []string{"localhost"}, time.Now().Add(24*time.Hour), intermediate)
+	certFile, keyFile := leaf.writeFiles(t, certDir, "leaf")
+
+	srv, tempDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.CertFile = certFile
+		cfg.KeyFile = keyFile
+	})
+	defer cleanup(srv, tempDir)
+
+	// Verified against the root only, the server must send the intermediate
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+	}
+
+	resp, err := client.Get(fmt.Sprintf("https://localhost:%s/echo/secure", srv.TLSPort))
+	if err != nil {
+		t.Fatalf("Failed to make TLS request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "secure" {
+		t.Errorf("Expected 200 'secure', got %d '%s'", resp.StatusCode, body)
+	}
+	if len(resp.TLS.PeerCertificates) != 3 {
+		t.Errorf("Expected the full chain of 3 certificates, got %d", len(resp.TLS.PeerCertificates))
+	}
+}
+
+// Test invalid TLS certificates are reported when creating the server
+func TestIntegration_TLSCertificateErrors(t *testing.T) {
+	certDir := t.TempDir()
+	root := newTestCA(t, "Test Root")
+	valid := newTestCert(t, "localhost", []string{"localhost"}, time.Now().Add(24*time.Hour), root)
+	expired := newTestCert(t, "localhost", []string{"localhost"}, time.Now().Add(-time.Minute), root)
+	other := newTestCert(t, "other", []string{"other"}, time.Now().Add(24*time.Hour), root)
+
+	validCert, validKey := valid.writeFiles(t, certDir, "valid")
+	expiredCert, expiredKey := expired.writeFiles(t, certDir, "expired")
+	_, otherKey := other.writeFiles(t, certDir, "other")
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		expected string
+	}{
+		{"expired", expiredCert, expiredKey, "expired"},
+		{"key mismatch", validCert, otherKey, "does not match"},
+		{"missing file", filepath.Join(certDir, "missing.crt"), validKey, "no such file"},
+		{"missing key", validCert, "", "both a certificate and a key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.CertFile = tt.certFile
+			cfg.KeyFile = tt.keyFile
+
+			_, err := server.NewServer(cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected an error containing '%s', got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+// Test listener addresses: bound ports, interfaces and TLS left out
+func TestIntegration_ListenerAddresses(t *testing.T) {
+	tlsServer, tempDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.Host = "127.0.0.1"
+		cfg.DevTLS = true
+	})
+	defer cleanup(tlsServer, tempDir)
+
+	if tlsServer.TLSPort == "0" || tlsServer.TLSPort == tlsServer.Port {
+		t.Fatalf("Expected the bound TLS port, got '%s'", tlsServer.TLSPort)
+	}
+	conn, err := tls.Dial("tcp", "127.0.0.1:"+tlsServer.TLSPort, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Failed to connect to the TLS listener: %v", err)
+	}
+	conn.Close()
+
+	// A second instance on the same host, without TLS despite a certificate
+	plainServer, plainDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.Host = "127.0.0.1"
+		cfg.DevTLS = true
+		cfg.TLSPort = ""
+	})
+	defer cleanup(plainServer, plainDir)
+
+	if plainServer.TLSPort != "" {
+		t.Errorf("Expected no TLS port, got '%s'", plainServer.TLSPort)
+	}
+	resp, err := makeHTTPRequest("GET", "http://127.0.0.1:"+plainServer.Port+"/echo/plain", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+}
+
+// Test certificates selected by SNI and routes selected by Host
+func TestIntegration_VirtualHostsTLS(t *testing.T) {
+	certDir := t.TempDir()
+	root := newTestCA(t, "Test Root")
+	defaultCert := newTestCert(t, "a.test", []string{"a.test"}, time.Now().Add(24*time.Hour), root)
+	wildcardCert := newTestCert(t, "*.b.test", []string{"*.b.test"}, time.Now().Add(24*time.Hour), root)
+	cCert := newTestCert(t, "c.test", []string{"c.test"}, time.Now().Add(24*time.Hour), root)
+
+	certFile, keyFile := defaultCert.writeFiles(t, certDir, "a")
+	wildcardFile, wildcardKey := wildcardCert.writeFiles(t, certDir, "b")
+	cFile, cKey := cCert.writeFiles(t, certDir, "c")
+
+	srv, tempDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.CertFile = certFile
+		cfg.KeyFile = keyFile
+		cfg.SNICertificates = []config.CertificateFiles{
+			{CertFile: wildcardFile, KeyFile: wildcardKey},
+			{CertFile: cFile, KeyFile: cKey},
+		}
+	})
+	defer cleanup(srv, tempDir)
+
+	site := func(name string) handler.Handler {
+		return handler.HandlerFunc(func(req *httpPkg.Request, resp *httpPkg.Response) {
+			resp.Body = name
+		})
+	}
+	srv.Router().Host("*.b.test").Get("/site", site("b"))
+	srv.Router().Host("c.test").Get("/site", site("c"))
+
+	// Every name resolves to the server, the client verifies against root
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, "localhost:"+srv.TLSPort)
+			},
+		},
+	}
+
+	tests := []struct {
+		host         string
+		expectedCert string
+		expectedBody string
+	}{
+		{"www.b.test", "*.b.test", "b"},
+		{"c.test", "c.test", "c"},
+		{"a.test", "a.test", ""},
+	}
+
+	for _, tt := range tests {
+		resp, err := client.Get("https://" + tt.host + "/site")
+		if err != nil {
+			t.Errorf("Failed to request %s: %v", tt.host, err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != tt.expectedCert {
+			t.Errorf("%s: expected certificate %s, got %s", tt.host, tt.expectedCert, cn)
+		}
+		if tt.expectedBody == "" && resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected the default routes to answer 404, got %d", tt.host, resp.StatusCode)
+		}
+		if tt.expectedBody != "" && string(body) != tt.expectedBody {
+			t.Errorf("%s: expected body '%s', got '%s'", tt.host, tt.expectedBody, body)
+		}
+	}
+
+	// Without SNI, e.g. by IP address, the default certificate is served
+	conn, err := tls.Dial("tcp", "127.0.0.1:"+srv.TLSPort, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Failed to connect without SNI: %v", err)
+	}
+	defer conn.Close()
+	if cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != "a.test" {
+		t.Errorf("Expected the default certificate without SNI, got %s", cn)
+	}
+}
+
+// Test certificate files replaced on disk are served to new connections
+// while established ones keep going
+func TestIntegration_TLSCertificateReload(t *testing.T) {
+	certDir := t.TempDir()
+	root := newTestCA(t, "Test Root")
+	first := newTestCert(t, "localhost", []string{"localhost"}, time.Now().Add(24*time.Hour), root)
+	certFile, keyFile := first.writeFiles(t, certDir, "server")
+
+	srv, tempDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.CertFile = certFile
+		cfg.KeyFile = keyFile
+		cfg.TLSReloadInterval = 20 * time.Millisecond
+	})
+	defer cleanup(srv, tempDir)
+
+	tlsConfig := &tls.Config{RootCAs: x509.NewCertPool(), ServerName: "localhost"}
+	tlsConfig.RootCAs.AddCert(root.cert)
+	servedSerial := func() string {
+		conn, err := tls.Dial("tcp", "localhost:"+srv.TLSPort, tlsConfig)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.String()
+	}
+
+	established, err := tls.Dial("tcp", "localhost:"+srv.TLSPort, tlsConfig)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer established.Close()
+
+	// A broken pair is not loaded, the current certificate stays
+	os.WriteFile(certFile, []byte("not a certificate"), 0600)
+	time.Sleep(100 * time.Millisecond)
+	if serial := servedSerial(); serial != first.cert.SerialNumber.String() {
+		t.Errorf("Expected the first certificate to stay after a broken update, got serial %s", serial)
+	}
+
+	second := newTestCert(t, "localhost", []string{"localhost"}, time.Now().Add(48*time.Hour), root)
+	second.writeFiles(t, certDir, "server")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for servedSerial() != second.cert.SerialNumber.String() && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if serial := servedSerial(); serial != second.cert.SerialNumber.String() {
+		t.Fatalf("Expected the replaced certificate to be served, got serial %s", serial)
+	}
+
+	// The connection made before the reload still works
+	established.Write([]byte("GET /echo/still-here HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	response, _ := io.ReadAll(established)
+	if !strings.HasPrefix(string(response), "HTTP/1.1 200") || !strings.HasSuffix(string(response), "still-here") {
+		t.Errorf("Expected the established connection to be served, got %q", response)
+	}
+
+	certs := srv.GetCertificates()
+	if len(certs) != 1 || certs[0].Serial != second.cert.SerialNumber.Text(16) {
+		t.Errorf("Expected the health metrics to report the new certificate, got %+v", certs)
+	}
+
+	// Rotation removing the files first: the new certificate is tried while
+	// the key is missing, the key coming back is a change too
+	third := newTestCert(t, "localhost", []string{"localhost"}, time.Now().Add(72*time.Hour), root)
+	os.Remove(keyFile)
+	os.WriteFile(certFile, third.certPem, 0600)
+	time.Sleep(100 * time.Millisecond)
+	os.WriteFile(keyFile, third.keyPem, 0600)
+
+	deadline = time.Now().Add(2 * time.Second)
+	for servedSerial() != third.cert.SerialNumber.String() && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if serial := servedSerial(); serial != third.cert.SerialNumber.String() {
+		t.Errorf("Expected the certificate rotated through a missing key to be served, got serial %s", serial)
+	}
+}
+
+// Test client certificates are verified and exposed to handlers
+func TestIntegration_MutualTLS(t *testing.T) {
+	certDir := t.TempDir()
+	serverRoot := newTestCA(t, "Server Root")
+	serverCert := newTestCert(t, "localhost", []string{"localhost"}, time.Now().Add(24*time.Hour), serverRoot)
+	certFile, keyFile := serverCert.writeFiles(t, certDir, "server")
+
+	clientRoot := newTestCA(t, "Client Root")
+	caFile, _ := clientRoot.writeFiles(t, certDir, "client-ca")
+	billing := newTestCert(t, "billing", []string{"billing.internal"}, time.Now().Add(24*time.Hour), clientRoot)
+	intruder := newTestCert(t, "billing", []string{"billing.internal"}, time.Now().Add(24*time.Hour), newTestCA(t, "Other Root"))
+
+	keyPair := func(c *testCert) tls.Certificate {
+		pair, err := tls.X509KeyPair(c.certPem, c.keyPem)
+		if err != nil {
+			t.Fatalf("Failed to load client key pair: %v", err)
+		}
+		return pair
+	}
+	get := func(port, path string, clientCerts ...tls.Certificate) (int, string, error) {
+		roots := x509.NewCertPool()
+		roots.AddCert(serverRoot.cert)
+		client := &http.Client{
+			Timeout:   5 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: clientCerts}},
+		}
+		resp, err := client.Get("https://localhost:" + port + path)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), nil
+	}
+
+	whoami := handler.HandlerFunc(func(req *httpPkg.Request, resp *httpPkg.Response) {
+		if req.ClientCert == nil {
+			resp.Body = "anonymous"
+			return
+		}
+		resp.Body = strings.Join([]string{
+			req.ClientCert.CommonName,
+			strings.Join(req.ClientCert.DNSNames, ","),
+			req.ClientCert.Fingerprint,
+			httpPkg.PrincipalFrom(req.Context()),
+		}, "|")
+	})
+	onlyBilling := middleware.ClientCertMiddleware(func(cert *httpPkg.ClientCert) bool {
+		return cert.CommonName == "billing"
+	})
+
+	fingerprint := sha256.Sum256(billing.cert.Raw)
+	expectedIdentity := "billing|billing.internal|" + hex.EncodeToString(fingerprint[:]) + "|CN=billing"
+
+	t.Run("required", func(t *testing.T) {
+		srv, tempDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+			cfg.CertFile, cfg.KeyFile = certFile, keyFile
+			cfg.ClientAuth = config.ClientAuthRequired
+			cfg.ClientCAFile = caFile
+		})
+		defer cleanup(srv, tempDir)
+		srv.Router().Get("/whoami", whoami)
+
+		if _, _, err := get(srv.TLSPort, "/whoami"); err == nil {
+			t.Error("Expected the handshake to fail without a client certificate")
+		}
+		if _, _, err := get(srv.TLSPort, "/whoami", keyPair(intruder)); err == nil {
+			t.Error("Expected the handshake to fail with a certificate from another CA")
+		}
+
+		status, body, err := get(srv.TLSPort, "/whoami", keyPair(billing))
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		if status != http.StatusOK || body != expectedIdentity {
+			t.Errorf("Expected 200 '%s', got %d '%s'", expectedIdentity, status, body)
+		}
+	})
+
+	t.Run("optional", func(t *testing.T) {
+		srv, tempDir := setupTestServerWithConfig(t, func(cfg *config.Config) {
+			cfg.CertFile, cfg.KeyFile = certFile, keyFile
+			cfg.ClientAuth = config.ClientAuthOptional
+			cfg.ClientCAFile = caFile
+		})
+		defer cleanup(srv, tempDir)
+		srv.Router().Get("/whoami", whoami)
+		srv.Router().Get("/billing", whoami, onlyBilling)
+
+		tests := []struct {
+			path           string
+			clientCerts    []tls.Certificate
+			expectedStatus int
+			expectedBody   string
+		}{
+			{"/whoami", nil, http.StatusOK, "anonymous"},
+			{"/whoami", []tls.Certificate{keyPair(billing)}, http.StatusOK, expectedIdentity},
+			{"/billing", nil, http.StatusForbidden, ""},
+			{"/billing", []tls.Certificate{keyPair(billing)}, http.StatusOK, expectedIdentity},
+		}
+
+		for _, tt := range tests {
+			status, body, err := get(srv.TLSPort, tt.path, tt.clientCerts...)
+			if err != nil {
+				t.Fatalf("Failed to make request: %v", err)
+			}
+			if status != tt.expectedStatus || body != tt.expectedBody {
+				t.Errorf("GET %s with %d certificates: expected %d '%s', got %d '%s'", tt.path, len(tt.clientCerts), tt.expectedStatus, tt.expectedBody, status, body)
+			}
+		}
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.CertFile, cfg.KeyFile = certFile, keyFile
+		cfg.ClientAuth = config.ClientAuthRequired
+
+		if _, err := server.NewServer(cfg); err == nil || !strings.Contains(err.Error(), "client CA") {
+			t.Errorf("Expected an error about the missing client CA, got %v", err)
+		}
+	})
+}
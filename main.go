@@ -2,7 +2,9 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"strings"
 
 	"github.com/codecrafters-io/http-server-starter-go/config"
 	"github.com/codecrafters-io/http-server-starter-go/server"
@@ -25,6 +27,42 @@ func parseFlags() *config.Config {
 	cfg := config.DefaultConfig()
 
 	flag.StringVar(&cfg.FileDir, "directory", cfg.FileDir, "specifies the directory where the files are stored, as an absolute path.")
+	flag.Func("concurrency", "how connections are run: \"pool\" (default) or \"goroutine\".", func(value string) error {
+		switch mode := config.ConcurrencyMode(value); mode {
+		case config.ConcurrencyPool, config.ConcurrencyPerConnection:
+			cfg.Concurrency = mode
+			return nil
+		}
+		return fmt.Errorf("unknown concurrency mode %q", value)
+	})
+	flag.IntVar(&cfg.MaxWorkers, "max-workers", cfg.MaxWorkers, "largest number of workers in pool mode.")
+	flag.IntVar(&cfg.MaxConnections, "max-connections", cfg.MaxConnections, "largest number of connections in goroutine mode, 0 for no limit.")
+	flag.Int64Var(&cfg.MaxBodySize, "max-body-size", cfg.MaxBodySize, "largest request body accepted, in bytes, 0 for no limit.")
+	flag.StringVar(&cfg.Host, "host", cfg.Host, "interface the listeners bind to, empty for all of them.")
+	flag.StringVar(&cfg.Port, "port", cfg.Port, "HTTP port, 0 picks a free one.")
+	flag.StringVar(&cfg.TLSHost, "tls-host", cfg.TLSHost, "interface the TLS listener binds to, defaults to -host.")
+	flag.StringVar(&cfg.TLSPort, "tls-port", cfg.TLSPort, "HTTPS port, 0 picks a free one, empty disables TLS.")
+	flag.StringVar(&cfg.CertFile, "tls-cert", cfg.CertFile, "PEM certificate, followed by its chain, served on the TLS port.")
+	flag.StringVar(&cfg.KeyFile, "tls-key", cfg.KeyFile, "PEM private key of the TLS certificate.")
+	flag.Func("tls-sni-cert", "additional certificate, as \"cert.pem,key.pem\", served to clients asking for one of its names; can be repeated.", func(value string) error {
+		certFile, keyFile, ok := strings.Cut(value, ",")
+		if !ok || certFile == "" || keyFile == "" {
+			return fmt.Errorf("expected \"cert.pem,key.pem\", got %q", value)
+		}
+		cfg.SNICertificates = append(cfg.SNICertificates, config.CertificateFiles{CertFile: certFile, KeyFile: keyFile})
+		return nil
+	})
+	flag.Func("tls-client-auth", "client certificates on the TLS port: \"none\" (default), \"optional\" or \"required\".", func(value string) error {
+		switch mode := config.ClientAuthMode(value); mode {
+		case config.ClientAuthNone, config.ClientAuthOptional, config.ClientAuthRequired:
+			cfg.ClientAuth = mode
+			return nil
+		}
+		return fmt.Errorf("unknown client auth mode %q", value)
+	})
+	flag.StringVar(&cfg.ClientCAFile, "tls-client-ca", cfg.ClientCAFile, "PEM bundle of the CAs client certificates are verified against.")
+	flag.DurationVar(&cfg.TLSReloadInterval, "tls-reload-interval", cfg.TLSReloadInterval, "how often the TLS files are checked for changes, 0 to only reload on SIGHUP.")
+	flag.BoolVar(&cfg.DevTLS, "tls-dev", cfg.DevTLS, "serve the embedded self-signed demo certificate on the TLS port, for development only.")
 	flag.Parse()
 
 	return cfg
@@ -3,21 +3,40 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/codecrafters-io/http-server-starter-go/server"
 )
 
+// TestMain runs main instead of the tests when asked to by
+// TestGracefulShutdown_Signal, the arguments are the server flags.
+func TestMain(m *testing.M) {
+	if os.Getenv("HTTP_SERVER_RUN_MAIN") == "1" {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
 func TestGracefulShutdown_WithActiveConnections(t *testing.T) {
 	server, err := server.NewServer(nil)
 	if err != nil {
 		t.Fatalf("Failed to create server: %v", err)
 	}
 
+	if err := server.Listen(); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
 	go server.Start()
-	time.Sleep(100 * time.Millisecond) //  time for server to start
 
 	port := server.Port
 
@@ -52,3 +71,78 @@ func TestGracefulShutdown_WithActiveConnections(t *testing.T) {
 		t.Errorf("Shutdown took too long: %v", duration)
 	}
 }
+
+// TestGracefulShutdown_Signal sends SIGTERM to the server process while an
+// upload is in flight, the process must only exit once it is drained.
+func TestGracefulShutdown_Signal(t *testing.T) {
+	// Pick a free port for the child process
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := strings.TrimPrefix(listener.Addr().String(), "127.0.0.1:")
+	listener.Close()
+
+	var output bytes.Buffer
+	cmd := exec.Command(os.Args[0], "-port", port, "-directory", t.TempDir(), "-tls-port", "")
+	cmd.Env = append(os.Environ(), "HTTP_SERVER_RUN_MAIN=1")
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start the server: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	defer cmd.Process.Kill()
+
+	var conn net.Conn
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if conn, err = net.Dial("tcp", "localhost:"+port); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Server not listening: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Half of the body is sent before the signal, the rest after
+	conn.Write([]byte("POST /files/upload.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhello"))
+	time.Sleep(100 * time.Millisecond)
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("Failed to send SIGTERM: %v", err)
+	}
+	select {
+	case err := <-exited:
+		t.Fatalf("Server exited before the upload completed: %v\n%s", err, output.String())
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	conn.Write([]byte("world"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", resp.StatusCode)
+	}
+	if !resp.Close {
+		t.Error("Expected the response to close the connection")
+	}
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("Server exited with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server did not exit after draining")
+	}
+	if !strings.Contains(output.String(), "All connections closed gracefully: 0 idle, 1 drained") {
+		t.Errorf("Expected the shutdown report, got:\n%s", output.String())
+	}
+}
@@ -3,7 +3,12 @@ package middleware
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -25,6 +30,11 @@ func NewChain(middlewares []Middleware) *Chain {
 	}
 }
 
+// Append returns a new chain running c's middlewares followed by middlewares.
+func (c Chain) Append(middlewares ...Middleware) Chain {
+	return Chain{middlewares: append(slices.Clip(c.middlewares), middlewares...)}
+}
+
 func (c *Chain) ContructMainHandler(h handler.Handler) handler.Handler {
 	for i := len(c.middlewares) - 1; i >= 0; i-- {
 		h = c.middlewares[i](h)
@@ -37,15 +47,20 @@ func GzipMiddleware() Middleware {
 		return handler.HandlerFunc(func(req *http.Request, resp *http.Response) {
 			next.Handle(req, resp)
 
-			encodings := req.Headers["Accept-Encoding"]
+			// A streamed response has already been sent as is
+			if resp.Committed() {
+				return
+			}
+
+			encodings := strings.Join(req.Headers.Values("Accept-Encoding"), ",")
 
 			if strings.Contains(encodings, "gzip") {
-				req.Headers["Content-Encoding"] = "gzip"
+				req.Headers.Set("Content-Encoding", "gzip")
 
 				var buf bytes.Buffer
 				zw := gzip.NewWriter(&buf)
 
-				_, err := zw.Write([]byte(resp.Body))
+				_, err := zw.Write([]byte(resp.BufferedBody()))
 				if err != nil {
 					fmt.Println("Error encoding the body", err)
 					return
@@ -57,9 +72,9 @@ func GzipMiddleware() Middleware {
 					return
 				}
 
-				resp.Body = buf.String()
-				resp.Headers["Content-Encoding"] = "gzip"
-				resp.Headers["Content-Length"] = strconv.Itoa(len(resp.Body))
+				resp.SetBody(buf.String())
+				resp.Headers.Set("Content-Encoding", "gzip")
+				resp.Headers.Set("Content-Length", strconv.Itoa(buf.Len()))
 			}
 		})
 	}
@@ -78,8 +93,144 @@ func LoggingMiddleware() Middleware {
 				req.Path,
 				resp.StatusCode,
 				elapsed.Milliseconds())
+			if id := http.RequestIDFrom(req.Context()); id != "" {
+				log += " - " + id
+			}
 
 			fmt.Println(log)
 		})
 	}
 }
+
+// MaxBodySizeMiddleware answers 413 to requests with a body larger than
+// limit bytes. Bodies announced with Content-Length, or waiting for a
+// 100 Continue, are rejected before being transmitted, the others once read
+// within the server wide limit.
+func MaxBodySizeMiddleware(limit int64) Middleware {
+	return func(next handler.Handler) handler.Handler {
+		return handler.HandlerFunc(func(req *http.Request, resp *http.Response) {
+			length, err := strconv.ParseInt(req.Headers.Get("Content-Length"), 10, 64)
+			if err == nil && length > limit {
+				resp.StatusCode = 413
+				return
+			}
+
+			if req.BodyConsumed() && int64(len(req.Body)) > limit {
+				resp.StatusCode = 413
+				return
+			}
+
+			// Enforced by ReadBody for a body not read yet
+			req.SetMaxBodySize(limit)
+			next.Handle(req, resp)
+		})
+	}
+}
+
+// RequestIDMiddleware gives every request an ID, available with
+// http.RequestIDFrom(req.Context()) and sent back in the X-Request-Id
+// response header. A valid X-Request-Id sent by the client is kept.
+func RequestIDMiddleware() Middleware {
+	return func(next handler.Handler) handler.Handler {
+		return handler.HandlerFunc(func(req *http.Request, resp *http.Response) {
+			id := req.Headers.Get("X-Request-Id")
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+
+			req.SetContext(http.WithRequestID(req.Context(), id))
+			resp.Headers.Set("X-Request-Id", id)
+			next.Handle(req, resp)
+		})
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts IDs of up to 128 printable ASCII characters.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// TimeoutMiddleware gives the request context a deadline of timeout, on top
+// of the server wide one. Handlers watching Request.Context() stop then.
+func TimeoutMiddleware(timeout time.Duration) Middleware {
+	return func(next handler.Handler) handler.Handler {
+		return handler.HandlerFunc(func(req *http.Request, resp *http.Response) {
+			parent := req.Context()
+			ctx, cancel := context.WithTimeout(parent, timeout)
+			defer cancel()
+
+			req.SetContext(ctx)
+			next.Handle(req, resp)
+			req.SetContext(parent)
+		})
+	}
+}
+
+// RecoveryMiddleware recovers from a panic in the handlers down the chain
+// with RecoverResponse, then calls onPanic, if not nil, e.g. to count it.
+func RecoveryMiddleware(onPanic func(recovered any)) Middleware {
+	return func(next handler.Handler) handler.Handler {
+		return handler.HandlerFunc(func(req *http.Request, resp *http.Response) {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					RecoverResponse(req, resp, recovered)
+					if onPanic != nil {
+						onPanic(recovered)
+					}
+				}
+			}()
+			next.Handle(req, resp)
+		})
+	}
+}
+
+// RecoverResponse logs a panic recovered while serving req along with the
+// stack trace, and replaces resp with a 500 closing the connection. A
+// response already committed is aborted instead. It must be called from the
+// deferred function that recovered.
+func RecoverResponse(req *http.Request, resp *http.Response, recovered any) {
+	fmt.Printf("Panic serving %s %s: %v\n%s", req.Method, req.Path, recovered, debug.Stack())
+
+	if resp.Committed() {
+		resp.Abort()
+		return
+	}
+
+	resp.StatusCode = 500
+	resp.StatusMessage = ""
+	resp.SetBody("")
+	resp.Headers = http.Header{}
+	resp.Headers.Set("Connection", "close")
+	if id := http.RequestIDFrom(req.Context()); id != "" {
+		resp.Headers.Set("X-Request-Id", id)
+	}
+}
+
+// ClientCertMiddleware only lets through requests authenticated with a
+// client certificate over mutual TLS that allow accepts, e.g. by subject or
+// fingerprint. Others are answered with 403.
+func ClientCertMiddleware(allow func(cert *http.ClientCert) bool) Middleware {
+	return func(next handler.Handler) handler.Handler {
+		return handler.HandlerFunc(func(req *http.Request, resp *http.Response) {
+			if req.ClientCert == nil || !allow(req.ClientCert) {
+				resp.StatusCode = 403
+				return
+			}
+			next.Handle(req, resp)
+		})
+	}
+}
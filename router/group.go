@@ -0,0 +1,72 @@
+package router
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/handler"
+	"github.com/codecrafters-io/http-server-starter-go/middleware"
+)
+
+// Group registers routes on a router under a common prefix, with a common
+// set of middlewares applied to those routes only.
+type Group struct {
+	router      *Router
+	prefix      string
+	middlewares []middleware.Middleware
+}
+
+// Use appends middlewares for the routes registered on the group afterwards.
+func (g *Group) Use(middlewares ...middleware.Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+// Group returns a nested group, its prefix and middlewares are added to g's.
+func (g *Group) Group(prefix string, middlewares ...middleware.Middleware) *Group {
+	return &Group{
+		router:      g.router,
+		prefix:      g.pattern(prefix),
+		middlewares: append(slices.Clip(g.middlewares), middlewares...),
+	}
+}
+
+func (g *Group) Handle(pattern string, handler handler.Handler, middlewares ...middleware.Middleware) {
+	g.HandleMethod(anyMethod, pattern, handler, middlewares...)
+}
+
+func (g *Group) HandleMethod(method string, pattern string, handler handler.Handler, middlewares ...middleware.Middleware) {
+	g.router.HandleMethod(method, g.pattern(pattern), handler, append(slices.Clip(g.middlewares), middlewares...)...)
+}
+
+func (g *Group) Get(pattern string, handler handler.Handler, middlewares ...middleware.Middleware) {
+	g.HandleMethod("GET", pattern, handler, middlewares...)
+}
+
+func (g *Group) Post(pattern string, handler handler.Handler, middlewares ...middleware.Middleware) {
+	g.HandleMethod("POST", pattern, handler, middlewares...)
+}
+
+func (g *Group) Put(pattern string, handler handler.Handler, middlewares ...middleware.Middleware) {
+	g.HandleMethod("PUT", pattern, handler, middlewares...)
+}
+
+func (g *Group) Delete(pattern string, handler handler.Handler, middlewares ...middleware.Middleware) {
+	g.HandleMethod("DELETE", pattern, handler, middlewares...)
+}
+
+// Mount serves every request below the group prefix joined with prefix with sub.
+func (g *Group) Mount(prefix string, sub *Router, middlewares ...middleware.Middleware) {
+	full := g.pattern(prefix)
+	g.router.HandleMethod(anyMethod, mountPattern(full), mountHandler(full, sub), append(slices.Clip(g.middlewares), middlewares...)...)
+}
+
+// pattern joins the group prefix and pattern.
+func (g *Group) pattern(pattern string) string {
+	if pattern == "/" || pattern == "" {
+		if g.prefix == "" {
+			return "/"
+		}
+		return g.prefix
+	}
+	return g.prefix + "/" + strings.TrimPrefix(pattern, "/")
+}
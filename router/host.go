@@ -0,0 +1,57 @@
+package router
+
+import (
+	"net"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/http"
+)
+
+// Host returns the router serving requests whose Host header names host,
+// created on first use. host is a domain name, or a wildcard such as
+// "*.example.com" matching a single label. Requests for other hosts, or
+// without Host, are served by r's own routes. The middlewares registered
+// with r.Use wrap the host routers too.
+func (r *Router) Host(host string) *Router {
+	host = normalizeHost(host)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if sub, ok := r.hosts[host]; ok {
+		return sub
+	}
+	if r.hosts == nil {
+		r.hosts = make(map[string]*Router)
+	}
+	sub := NewRouter()
+	r.hosts[host] = sub
+	r.compiled.Store(nil)
+	return sub
+}
+
+// lookupHost returns the router of hosts serving host: an exact match first,
+// then a wildcard on its parent domain.
+func lookupHost(hosts map[string]*Router, host string) *Router {
+	if sub, ok := hosts[host]; ok {
+		return sub
+	}
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		return hosts["*"+host[i:]]
+	}
+	return nil
+}
+
+// requestHost returns the host named by the Host header of request, without
+// port.
+func requestHost(request *http.Request) string {
+	host := request.Headers.Get("Host")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return normalizeHost(host)
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
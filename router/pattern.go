@@ -0,0 +1,79 @@
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	staticSegment   segmentKind = iota // literal text, e.g. "files"
+	paramSegment                       // "{name}" or "{name:regexp}"
+	wildcardSegment                    // "*name", matches the rest of the path
+)
+
+type segment struct {
+	kind  segmentKind
+	value string // literal text or parameter name
+	regex *regexp.Regexp
+}
+
+// pattern is a parsed route pattern such as "/users/{id:[0-9]+}/*rest".
+type pattern struct {
+	raw      string
+	segments []segment
+	// static is set when the pattern has no parameters, such patterns also
+	// match any path below them ("/echo" matches "/echo/hello") unless a
+	// longer pattern does
+	static bool
+}
+
+func parsePattern(raw string) (*pattern, error) {
+	if !strings.HasPrefix(raw, "/") {
+		return nil, fmt.Errorf("pattern %q must start with '/'", raw)
+	}
+
+	p := &pattern{raw: raw, static: true}
+	parts := strings.Split(strings.Trim(raw, "/"), "/")
+	if parts[0] == "" {
+		parts = nil
+	}
+
+	for i, part := range parts {
+		switch {
+		case strings.HasPrefix(part, "*"):
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("pattern %q: wildcard must be the last segment", raw)
+			}
+			name := part[1:]
+			if name == "" {
+				return nil, fmt.Errorf("pattern %q: wildcard must be named", raw)
+			}
+			p.segments = append(p.segments, segment{kind: wildcardSegment, value: name})
+			p.static = false
+
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name, expr, hasExpr := strings.Cut(part[1:len(part)-1], ":")
+			if name == "" {
+				return nil, fmt.Errorf("pattern %q: parameter must be named", raw)
+			}
+			seg := segment{kind: paramSegment, value: name}
+			if hasExpr {
+				regex, err := regexp.Compile("^(?:" + expr + ")$")
+				if err != nil {
+					return nil, fmt.Errorf("pattern %q: invalid regexp for %q: %w", raw, name, err)
+				}
+				seg.regex = regex
+			}
+			p.segments = append(p.segments, seg)
+			p.static = false
+
+		default:
+			p.segments = append(p.segments, segment{kind: staticSegment, value: part})
+		}
+	}
+
+	return p, nil
+}
@@ -1,54 +1,249 @@
 package router
 
 import (
+	"fmt"
+	"maps"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/codecrafters-io/http-server-starter-go/handler"
 	"github.com/codecrafters-io/http-server-starter-go/http"
 	"github.com/codecrafters-io/http-server-starter-go/middleware"
 )
 
+// anyMethod is the key of handlers registered without a method
+const anyMethod = ""
+
+type route struct {
+	pattern  *pattern
+	handlers map[string]handler.Handler
+}
+
+// allowedMethods returns the methods accepted by the route, in a stable order,
+// including the HEAD and OPTIONS methods answered automatically.
+func (rt *route) allowedMethods() []string {
+	methods := []string{}
+	for method := range rt.handlers {
+		methods = append(methods, method)
+	}
+	if _, ok := rt.handlers["GET"]; ok {
+		methods = append(methods, "HEAD")
+	}
+	methods = append(methods, "OPTIONS")
+
+	slices.Sort(methods)
+	return slices.Compact(methods)
+}
+
+// handlerFor returns the handler serving method on this route, or nil.
+func (rt *route) handlerFor(method string) handler.Handler {
+	if h, ok := rt.handlers[method]; ok {
+		return h
+	}
+	if h, ok := rt.handlers["GET"]; ok && method == "HEAD" {
+		return h
+	}
+	return rt.handlers[anyMethod]
+}
+
 type Router struct {
-	routes map[string]handler.Handler
-	chain  middleware.Chain
+	// mu guards root, chain and hosts, which are only modified by
+	// registrations
+	mu    sync.Mutex
+	root  *node
+	chain middleware.Chain
+	hosts map[string]*Router
+
+	// compiled is the handler serving requests, built from a copy of root
+	// wrapped once by chain. It is reset on every change and rebuilt by the
+	// next request, requests in flight keep using the previous one.
+	compiled atomic.Pointer[handler.Handler]
 }
 
 func NewRouter() *Router {
-	return &Router{
-		routes: make(map[string]handler.Handler),
+	return &Router{root: newNode()}
+}
+
+// Handle registers handler for pattern, whatever the request method. A pattern
+// is a slash separated path whose segments can be literals, parameters
+// ("{name}", or "{id:[0-9]+}" to restrict them with a regexp) or a trailing
+// wildcard ("*rest") capturing the remainder of the path. Captured values are
+// available through Request.PathParam. Patterns made only of literals also
+// match every path below them, except "/" which only matches itself. Handle
+// panics if the pattern is invalid.
+// The optional middlewares only apply to this route, inside the ones
+// registered with Use.
+func (r *Router) Handle(pattern string, handler handler.Handler, middlewares ...middleware.Middleware) {
+	r.HandleMethod(anyMethod, pattern, handler, middlewares...)
+}
+
+// HandleMethod registers handler for requests to pattern using method.
+// HEAD requests are served by the GET handler without sending the body,
+// OPTIONS requests are answered with the Allow header, and any other method
+// gets a 405 response. It panics if the pattern is invalid or if method is
+// already registered for it.
+func (r *Router) HandleMethod(method string, pattern string, h handler.Handler, middlewares ...middleware.Middleware) {
+	method = strings.ToUpper(method)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.compiled.Store(nil)
+
+	p, err := parsePattern(pattern)
+	if err != nil {
+		panic("router: " + err.Error())
 	}
+
+	n, err := r.root.insert(p)
+	if err != nil {
+		panic("router: " + err.Error())
+	}
+	if n.route == nil {
+		n.route = &route{pattern: p, handlers: make(map[string]handler.Handler)}
+	}
+	rt := n.route
+
+	if _, exists := rt.handlers[method]; exists {
+		panic(fmt.Sprintf("router: %s %s is already registered", method, pattern))
+	}
+	rt.handlers[method] = middleware.NewChain(middlewares).ContructMainHandler(h)
+}
+
+func (r *Router) Get(pattern string, handler handler.Handler, middlewares ...middleware.Middleware) {
+	r.HandleMethod("GET", pattern, handler, middlewares...)
+}
+
+func (r *Router) Post(pattern string, handler handler.Handler, middlewares ...middleware.Middleware) {
+	r.HandleMethod("POST", pattern, handler, middlewares...)
+}
+
+func (r *Router) Put(pattern string, handler handler.Handler, middlewares ...middleware.Middleware) {
+	r.HandleMethod("PUT", pattern, handler, middlewares...)
 }
 
-func (r *Router) Handle(pattern string, handler handler.Handler) {
-	r.routes[pattern] = handler
+func (r *Router) Delete(pattern string, handler handler.Handler, middlewares ...middleware.Middleware) {
+	r.HandleMethod("DELETE", pattern, handler, middlewares...)
 }
 
 func (r *Router) ServeHTTP(request *http.Request, response *http.Response) {
-	mainHandler := handler.HandlerFunc(func(req *http.Request, res *http.Response) {
-		if request.Path == "/" {
-			response.StatusCode = 200
-			return
+	h := r.compiled.Load()
+	if h == nil {
+		h = r.compile()
+	}
+	(*h).Handle(request, response)
+}
+
+// compile builds the handler serving requests from a snapshot of the routing
+// tree, so registrations made later do not affect it.
+func (r *Router) compile() *handler.Handler {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Another request may have compiled it while we were waiting
+	if h := r.compiled.Load(); h != nil {
+		return h
+	}
+
+	root := r.root.clone()
+	hosts := maps.Clone(r.hosts)
+	mainHandler := handler.HandlerFunc(func(request *http.Request, response *http.Response) {
+		if len(hosts) > 0 {
+			if sub := lookupHost(hosts, requestHost(request)); sub != nil {
+				sub.ServeHTTP(request, response)
+				return
+			}
 		}
 
-		pathFound := false
-		for pattern, handler := range r.routes {
-			if strings.HasPrefix(request.Path, pattern+"/") || request.Path == pattern {
-				handler.Handle(request, response)
-				pathFound = true
-				break
+		result := lookupResult{method: request.Method}
+		if root.lookup(splitPath(request.Path), nil, &result) {
+			if len(result.params) > 0 {
+				params := make(map[string]string, len(result.params))
+				for _, p := range result.params {
+					request.SetPathParam(p.name, p.value)
+					params[p.name] = p.value
+				}
+				request.SetContext(http.WithPathParams(request.Context(), params))
 			}
+			result.route.handlerFor(request.Method).Handle(request, response)
+			return
 		}
 
-		if !pathFound {
+		// No route serves the method, allowed lists the methods accepted by
+		// routes matching the path, if any
+		if result.allowed == nil {
 			response.StatusCode = 404
+			return
 		}
+
+		slices.Sort(result.allowed)
+		response.Headers.Set("Allow", strings.Join(slices.Compact(result.allowed), ", "))
+		if request.Method == "OPTIONS" {
+			response.StatusCode = 204
+			return
+		}
+		response.StatusCode = 405
 	})
 
-	// TODO : avoid constructing the main handler everytime
-	finalHandler := r.chain.ContructMainHandler(mainHandler)
-	finalHandler.Handle(request, response)
+	h := r.chain.ContructMainHandler(mainHandler)
+	r.compiled.Store(&h)
+	return &h
 }
 
+// Use appends middlewares to the chain wrapping every request, including
+// the ones answered with 404 or 405.
 func (r *Router) Use(middlewares ...middleware.Middleware) {
-	r.chain = *middleware.NewChain(middlewares)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.chain = r.chain.Append(middlewares...)
+	r.compiled.Store(nil)
+}
+
+// Group returns a group registering its routes under prefix, wrapped by
+// middlewares in addition to the router ones.
+func (r *Router) Group(prefix string, middlewares ...middleware.Middleware) *Group {
+	return &Group{router: r, prefix: strings.TrimSuffix(prefix, "/"), middlewares: middlewares}
+}
+
+// Mount serves every request whose path is prefix or below it with sub.
+// The prefix is stripped from Request.Path and Request.RawPath before sub
+// sees it, and sub keeps its own middlewares. prefix must only contain
+// literal segments.
+func (r *Router) Mount(prefix string, sub *Router, middlewares ...middleware.Middleware) {
+	r.HandleMethod(anyMethod, mountPattern(prefix), mountHandler(prefix, sub), middlewares...)
+}
+
+// mountPattern returns the pattern matching prefix and every path below it.
+// "/" alone only matches itself, a wildcard is needed at the root.
+func mountPattern(prefix string) string {
+	if strings.Trim(prefix, "/") == "" {
+		return "/*mounted"
+	}
+	return prefix
+}
+
+func mountHandler(prefix string, sub *Router) handler.Handler {
+	p, err := parsePattern(prefix)
+	if err != nil || !p.static {
+		panic(fmt.Sprintf("router: cannot mount on %q, prefix must be literal", prefix))
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	return handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		path, rawPath := req.Path, req.RawPath
+		req.Path = stripPrefix(path, prefix)
+		req.RawPath = stripPrefix(rawPath, prefix)
+		sub.ServeHTTP(req, res)
+		req.Path, req.RawPath = path, rawPath
+	})
+}
+
+func stripPrefix(path, prefix string) string {
+	path = strings.TrimPrefix(path, prefix)
+	if path == "" {
+		return "/"
+	}
+	return path
 }
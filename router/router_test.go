@@ -1,21 +1,57 @@
 package router
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/codecrafters-io/http-server-starter-go/handler"
 	"github.com/codecrafters-io/http-server-starter-go/http"
+	"github.com/codecrafters-io/http-server-starter-go/middleware"
 )
 
 func TestRouterServeHTTP_RootPath(t *testing.T) {
+	if response := serve(NewRouter(), "GET", "/"); response.StatusCode != 404 {
+		t.Errorf("Expected status 404 for an unregistered root path, got %d", response.StatusCode)
+	}
+
+	root := handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.StatusCode = 200
+		res.Body = "root " + req.Path
+	})
+
 	r := NewRouter()
-	request := &http.Request{Path: "/", Headers: map[string]string{}}
-	response := &http.Response{Headers: map[string]string{}}
+	r.Get("/", root)
+	sub := NewRouter()
+	sub.Get("/", root)
+	r.Mount("/api", sub)
+	r.Host("example.com").Get("/", root)
 
-	r.ServeHTTP(request, response)
+	tests := []struct {
+		method         string
+		path           string
+		host           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"GET", "/", "", 200, "root /"},
+		{"POST", "/", "", 405, ""},
+		{"GET", "/missing", "", 404, ""},
+		{"GET", "/api", "", 200, "root /"},
+		{"GET", "/", "example.com", 200, "root /"},
+	}
 
-	if response.StatusCode != 200 {
-		t.Errorf("Expected status 200 for root path, got %d", response.StatusCode)
+	for _, tt := range tests {
+		request := &http.Request{Method: tt.method, Path: tt.path, Headers: http.Header{}}
+		if tt.host != "" {
+			request.Headers.Set("Host", tt.host)
+		}
+		response := &http.Response{Headers: http.Header{}}
+
+		r.ServeHTTP(request, response)
+
+		if response.StatusCode != tt.expectedStatus || response.Body != tt.expectedBody {
+			t.Errorf("%s %s on host %q: expected %d %q, got %d %q", tt.method, tt.path, tt.host, tt.expectedStatus, tt.expectedBody, response.StatusCode, response.Body)
+		}
 	}
 }
 
@@ -26,8 +62,8 @@ func TestRouterServeHTTP_RegisteredRoute(t *testing.T) {
 		handlerCalled = true
 		res.StatusCode = 201
 	}))
-	request := &http.Request{Path: "/echo/hello", Headers: map[string]string{}}
-	response := &http.Response{Headers: map[string]string{}}
+	request := &http.Request{Path: "/echo/hello", Headers: http.Header{}}
+	response := &http.Response{Headers: http.Header{}}
 
 	r.ServeHTTP(request, response)
 
@@ -41,8 +77,8 @@ func TestRouterServeHTTP_RegisteredRoute(t *testing.T) {
 
 func TestRouterServeHTTP_UnregisteredRoute(t *testing.T) {
 	r := NewRouter()
-	request := &http.Request{Path: "/notfound", Headers: map[string]string{}}
-	response := &http.Response{Headers: map[string]string{}}
+	request := &http.Request{Path: "/notfound", Headers: http.Header{}}
+	response := &http.Response{Headers: http.Header{}}
 
 	r.ServeHTTP(request, response)
 
@@ -50,3 +86,626 @@ func TestRouterServeHTTP_UnregisteredRoute(t *testing.T) {
 		t.Errorf("Expected status 404 for unregistered route, got %d", response.StatusCode)
 	}
 }
+
+func TestRouterServeHTTP_PathParams(t *testing.T) {
+	tests := []struct {
+		name           string
+		pattern        string
+		path           string
+		expectedStatus int
+		expected       map[string]string
+	}{
+		{"Named param", "/files/{name}", "/files/report.txt", 200, map[string]string{"name": "report.txt"}},
+		{"Empty param", "/files/{name}", "/files/", 404, nil},
+		{"Extra segment", "/files/{name}", "/files/a/b", 404, nil},
+		{"Regexp param", "/users/{id:[0-9]+}", "/users/42", 200, map[string]string{"id": "42"}},
+		{"Regexp mismatch", "/users/{id:[0-9]+}", "/users/abc", 404, nil},
+		{"Several params", "/users/{id}/posts/{post}", "/users/7/posts/hello", 200, map[string]string{"id": "7", "post": "hello"}},
+		{"Wildcard", "/static/*rest", "/static/css/site.css", 200, map[string]string{"rest": "css/site.css"}},
+		{"Empty wildcard", "/static/*rest", "/static/", 200, map[string]string{"rest": ""}},
+		{"Wildcard without slash", "/static/*rest", "/static", 200, map[string]string{"rest": ""}},
+		{"Wildcard prefix mismatch", "/static/*rest", "/statics/a", 404, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter()
+			var captured *http.Request
+			r.Handle(tt.pattern, handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+				captured = req
+				res.StatusCode = 200
+			}))
+			request := &http.Request{Path: tt.path, Headers: http.Header{}}
+			response := &http.Response{Headers: http.Header{}}
+
+			r.ServeHTTP(request, response)
+
+			if response.StatusCode != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, response.StatusCode)
+			}
+			for name, value := range tt.expected {
+				if got := captured.PathParam(name); got != value {
+					t.Errorf("Expected param %s='%s', got '%s'", name, value, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRouterHandle_InvalidPatternPanics(t *testing.T) {
+	patterns := []string{"files", "/static/*", "/a/*rest/b", "/users/{}", "/users/{id:[0-9}"}
+
+	for _, pattern := range patterns {
+		t.Run(pattern, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected panic for pattern %q", pattern)
+				}
+			}()
+			NewRouter().Handle(pattern, handler.HandlerFunc(func(req *http.Request, res *http.Response) {}))
+		})
+	}
+}
+
+func TestRouterServeHTTP_MethodRouting(t *testing.T) {
+	r := NewRouter()
+	r.Get("/files/{name}", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.StatusCode = 200
+		res.Body = "read " + req.PathParam("name")
+	}))
+	r.Post("/files/{name}", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.StatusCode = 201
+	}))
+	r.HandleMethod("patch", "/files/{name}", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.StatusCode = 202
+	}))
+
+	tests := []struct {
+		method         string
+		expectedStatus int
+		expectedBody   string
+		expectedAllow  string
+	}{
+		{"GET", 200, "read a.txt", ""},
+		{"HEAD", 200, "read a.txt", ""},
+		{"POST", 201, "", ""},
+		{"PATCH", 202, "", ""},
+		{"PUT", 405, "", "GET, HEAD, OPTIONS, PATCH, POST"},
+		{"DELETE", 405, "", "GET, HEAD, OPTIONS, PATCH, POST"},
+		{"OPTIONS", 204, "", "GET, HEAD, OPTIONS, PATCH, POST"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			request := &http.Request{Method: tt.method, Path: "/files/a.txt", Headers: http.Header{}}
+			response := &http.Response{Headers: http.Header{}}
+
+			r.ServeHTTP(request, response)
+
+			if response.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, response.StatusCode)
+			}
+			if response.Body != tt.expectedBody {
+				t.Errorf("Expected body '%s', got '%s'", tt.expectedBody, response.Body)
+			}
+			if response.Headers.Get("Allow") != tt.expectedAllow {
+				t.Errorf("Expected Allow '%s', got '%s'", tt.expectedAllow, response.Headers.Get("Allow"))
+			}
+		})
+	}
+}
+
+func TestRouterServeHTTP_AnyMethodRoute(t *testing.T) {
+	r := NewRouter()
+	r.Handle("/echo", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.StatusCode = 200
+	}))
+
+	for _, method := range []string{"GET", "POST", "PUT", "OPTIONS"} {
+		request := &http.Request{Method: method, Path: "/echo", Headers: http.Header{}}
+		response := &http.Response{Headers: http.Header{}}
+
+		r.ServeHTTP(request, response)
+
+		if response.StatusCode != 200 {
+			t.Errorf("Expected status 200 for %s, got %d", method, response.StatusCode)
+		}
+	}
+}
+
+func TestRouterHandleMethod_DuplicatePanics(t *testing.T) {
+	r := NewRouter()
+	h := handler.HandlerFunc(func(req *http.Request, res *http.Response) {})
+	r.Get("/files/{name}", h)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic when registering GET /files/{name} twice")
+		}
+	}()
+	r.Get("/files/{name}", h)
+}
+
+func TestRouterServeHTTP_Precedence(t *testing.T) {
+	r := NewRouter()
+	register := func(pattern string) {
+		r.Handle(pattern, handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+			res.StatusCode = 200
+			res.Body = pattern
+		}))
+	}
+	register("/files")
+	register("/files/private")
+	register("/files/{name}")
+	register("/files/{id:[0-9]+}")
+	register("/files/*rest")
+	register("/users/{id}/profile")
+	register("/users/*rest")
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/files", "/files"},
+		{"/files/private", "/files/private"},
+		{"/files/private/secret.txt", "/files/private"},
+		{"/files/42", "/files/{id:[0-9]+}"},
+		{"/files/report.txt", "/files/{name}"},
+		{"/files/a/b", "/files/*rest"},
+		{"/users/7/profile", "/users/{id}/profile"},
+		{"/users/7/settings", "/users/*rest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			// Repeat to make sure the choice does not depend on iteration order
+			for range 20 {
+				request := &http.Request{Method: "GET", Path: tt.path, Headers: http.Header{}}
+				response := &http.Response{Headers: http.Header{}}
+
+				r.ServeHTTP(request, response)
+
+				if response.Body != tt.expected {
+					t.Fatalf("Expected route '%s', got '%s'", tt.expected, response.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestRouterServeHTTP_LongestStaticPrefix(t *testing.T) {
+	r := NewRouter()
+	r.Handle("/files", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.Body = "files"
+	}))
+	r.Handle("/files/private", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.Body = "private"
+	}))
+
+	for path, expected := range map[string]string{
+		"/files/public/a.txt":  "files",
+		"/files/private/a.txt": "private",
+		"/files/private":       "private",
+		"/files/":              "files",
+	} {
+		request := &http.Request{Path: path, Headers: http.Header{}}
+		response := &http.Response{Headers: http.Header{}}
+
+		r.ServeHTTP(request, response)
+
+		if response.Body != expected {
+			t.Errorf("Path %s: expected handler '%s', got '%s'", path, expected, response.Body)
+		}
+	}
+}
+
+func TestRouterHandle_ConflictingParamsPanic(t *testing.T) {
+	r := NewRouter()
+	h := handler.HandlerFunc(func(req *http.Request, res *http.Response) {})
+	r.Handle("/users/{id}", h)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic for conflicting parameter names")
+		}
+	}()
+	r.Handle("/users/{name}/posts", h)
+}
+
+func benchmarkRouter(b *testing.B, numberOfRoutes int, path string) {
+	r := NewRouter()
+	h := handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.StatusCode = 200
+	})
+	for i := range numberOfRoutes {
+		r.Get(fmt.Sprintf("/api/v1/resource%d", i), h)
+		r.Get(fmt.Sprintf("/api/v1/resource%d/{id}", i), h)
+		r.Get(fmt.Sprintf("/static%d/*rest", i), h)
+	}
+
+	request := &http.Request{Method: "GET", Path: path, Headers: http.Header{}}
+	response := &http.Response{Headers: http.Header{}}
+
+	// The first request compiles the router, it is not part of the cost of
+	// a request
+	r.ServeHTTP(request, response)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		r.ServeHTTP(request, response)
+	}
+}
+
+func BenchmarkRouter(b *testing.B) {
+	for _, numberOfRoutes := range []int{10, 100, 1000, 5000} {
+		b.Run(fmt.Sprintf("static/%d", numberOfRoutes), func(b *testing.B) {
+			benchmarkRouter(b, numberOfRoutes, fmt.Sprintf("/api/v1/resource%d", numberOfRoutes-1))
+		})
+		b.Run(fmt.Sprintf("param/%d", numberOfRoutes), func(b *testing.B) {
+			benchmarkRouter(b, numberOfRoutes, fmt.Sprintf("/api/v1/resource%d/123", numberOfRoutes-1))
+		})
+		b.Run(fmt.Sprintf("wildcard/%d", numberOfRoutes), func(b *testing.B) {
+			benchmarkRouter(b, numberOfRoutes, fmt.Sprintf("/static%d/css/site.css", numberOfRoutes-1))
+		})
+	}
+}
+
+// tagMiddleware appends name to the X-Trace response header.
+func tagMiddleware(name string) middleware.Middleware {
+	return func(next handler.Handler) handler.Handler {
+		return handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+			res.Headers.Set("X-Trace", res.Headers.Get("X-Trace")+name+";")
+			next.Handle(req, res)
+		})
+	}
+}
+
+func serve(r *Router, method, path string) *http.Response {
+	request := &http.Request{Method: method, Path: path, Headers: http.Header{}}
+	response := &http.Response{Headers: http.Header{}}
+	r.ServeHTTP(request, response)
+	return response
+}
+
+func TestRouterUse_Appends(t *testing.T) {
+	r := NewRouter()
+	r.Use(tagMiddleware("first"))
+	r.Use(tagMiddleware("second"))
+
+	response := serve(r, "GET", "/missing")
+
+	if response.Headers.Get("X-Trace") != "first;second;" {
+		t.Errorf("Expected both middlewares to run in order, got '%s'", response.Headers.Get("X-Trace"))
+	}
+}
+
+func TestRouterGroup_PrefixAndMiddlewares(t *testing.T) {
+	r := NewRouter()
+	r.Use(tagMiddleware("global"))
+	ok := handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.StatusCode = 200
+	})
+
+	r.Get("/health", ok)
+	admin := r.Group("/admin", tagMiddleware("admin"))
+	admin.Get("/users/{id}", ok)
+	admin.Post("/users/{id}", ok, tagMiddleware("upload"))
+	reports := admin.Group("/reports", tagMiddleware("reports"))
+	reports.Get("/", ok)
+
+	tests := []struct {
+		method   string
+		path     string
+		status   int
+		expected string
+	}{
+		{"GET", "/health", 200, "global;"},
+		{"GET", "/admin/users/1", 200, "global;admin;"},
+		{"POST", "/admin/users/1", 200, "global;admin;upload;"},
+		{"GET", "/admin/reports", 200, "global;admin;reports;"},
+		{"GET", "/users/1", 404, "global;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			response := serve(r, tt.method, tt.path)
+			if response.StatusCode != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, response.StatusCode)
+			}
+			if response.Headers.Get("X-Trace") != tt.expected {
+				t.Errorf("Expected middlewares '%s', got '%s'", tt.expected, response.Headers.Get("X-Trace"))
+			}
+		})
+	}
+}
+
+func TestRouterMount_Root(t *testing.T) {
+	sub := NewRouter()
+	sub.Get("/items", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.Body = "items " + req.Path
+	}))
+
+	r := NewRouter()
+	r.Get("/health", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.Body = "health"
+	}))
+	r.Mount("/", sub)
+
+	if response := serve(r, "GET", "/items"); response.Body != "items /items" {
+		t.Errorf("Expected the mounted router to serve /items, got %q", response.Body)
+	}
+	if response := serve(r, "GET", "/health"); response.Body != "health" {
+		t.Errorf("Expected the router's own route to win, got %q", response.Body)
+	}
+}
+
+func TestRouterMount_SubRouter(t *testing.T) {
+	sub := NewRouter()
+	sub.Use(tagMiddleware("team"))
+	sub.Get("/items/{id}", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.StatusCode = 200
+		res.Body = req.Path + " " + req.PathParam("id")
+	}))
+
+	r := NewRouter()
+	r.Use(tagMiddleware("global"))
+	r.Mount("/team", sub, tagMiddleware("mount"))
+
+	response := serve(r, "GET", "/team/items/3")
+	if response.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", response.StatusCode)
+	}
+	if response.Body != "/items/3 3" {
+		t.Errorf("Expected stripped path and param, got '%s'", response.Body)
+	}
+	if response.Headers.Get("X-Trace") != "global;mount;team;" {
+		t.Errorf("Expected middlewares 'global;mount;team;', got '%s'", response.Headers.Get("X-Trace"))
+	}
+
+	if response := serve(r, "POST", "/team/items/3"); response.StatusCode != 405 {
+		t.Errorf("Expected status 405 from sub router, got %d", response.StatusCode)
+	}
+	if response := serve(r, "GET", "/team/unknown"); response.StatusCode != 404 {
+		t.Errorf("Expected status 404 from sub router, got %d", response.StatusCode)
+	}
+	if response := serve(r, "GET", "/teams"); response.StatusCode != 404 {
+		t.Errorf("Expected status 404 outside mount prefix, got %d", response.StatusCode)
+	}
+}
+
+func BenchmarkRouter_WithMiddlewares(b *testing.B) {
+	passThrough := func(next handler.Handler) handler.Handler {
+		return handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+			next.Handle(req, res)
+		})
+	}
+
+	r := NewRouter()
+	r.Use(passThrough, passThrough, passThrough, passThrough)
+	r.Get("/echo/{message}", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.StatusCode = 200
+	}), passThrough)
+	r.Get("/health", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.StatusCode = 200
+	}))
+
+	for _, path := range []string{"/health", "/echo/hello"} {
+		b.Run(path, func(b *testing.B) {
+			request := &http.Request{Method: "GET", Path: path, Headers: http.Header{}}
+			response := &http.Response{Headers: http.Header{}}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				r.ServeHTTP(request, response)
+			}
+		})
+	}
+}
+
+func TestRouterServeHTTP_ChainBuiltOnce(t *testing.T) {
+	constructions := 0
+	counting := func(next handler.Handler) handler.Handler {
+		constructions++
+		return next
+	}
+
+	r := NewRouter()
+	r.Use(counting)
+	r.Get("/echo/{message}", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.StatusCode = 200
+	}))
+
+	for range 10 {
+		serve(r, "GET", "/echo/hello")
+	}
+	if constructions != 1 {
+		t.Errorf("Expected the chain to be built once, got %d constructions", constructions)
+	}
+}
+
+func TestRouterServeHTTP_RecompilesOnChange(t *testing.T) {
+	r := NewRouter()
+	ok := handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.StatusCode = 200
+	})
+
+	if response := serve(r, "GET", "/late"); response.StatusCode != 404 {
+		t.Fatalf("Expected status 404 before registration, got %d", response.StatusCode)
+	}
+
+	r.Get("/late", ok)
+	if response := serve(r, "GET", "/late"); response.StatusCode != 200 {
+		t.Errorf("Expected status 200 after registration, got %d", response.StatusCode)
+	}
+
+	r.Use(tagMiddleware("late"))
+	if response := serve(r, "GET", "/late"); response.Headers.Get("X-Trace") != "late;" {
+		t.Errorf("Expected middleware added at runtime to run, got '%s'", response.Headers.Get("X-Trace"))
+	}
+}
+
+func TestRouterServeHTTP_ConcurrentRegistration(t *testing.T) {
+	r := NewRouter()
+	ok := handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.StatusCode = 200
+	})
+	r.Get("/stable", ok)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 200 {
+			r.Get(fmt.Sprintf("/dynamic/%d", i), ok)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			if response := serve(r, "GET", "/dynamic/199"); response.StatusCode != 200 {
+				t.Errorf("Expected status 200 for last registered route, got %d", response.StatusCode)
+			}
+			return
+		default:
+			if response := serve(r, "GET", "/stable"); response.StatusCode != 200 {
+				t.Fatalf("Expected status 200 during registrations, got %d", response.StatusCode)
+			}
+		}
+	}
+}
+
+func TestRouterHandle_PerRouteBodyLimit(t *testing.T) {
+	r := NewRouter()
+	upload := handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		body, err := req.ReadBody()
+		if err != nil {
+			res.StatusCode = http.ErrorStatus(err)
+			return
+		}
+		res.StatusCode = 201
+		res.Body = body
+	})
+	r.Post("/avatars/{name}", upload, middleware.MaxBodySizeMiddleware(4))
+	r.Post("/files/{name}", upload)
+
+	tests := []struct {
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"/avatars/a", "tiny", 201},
+		{"/avatars/a", "too large", 413},
+		{"/files/a", "too large", 201},
+	}
+
+	for _, tt := range tests {
+		request := &http.Request{Method: "POST", Path: tt.path, Headers: http.Header{}, Body: tt.body}
+		request.Headers.Set("Content-Length", fmt.Sprint(len(tt.body)))
+		response := &http.Response{Headers: http.Header{}}
+
+		r.ServeHTTP(request, response)
+
+		if response.StatusCode != tt.expectedStatus {
+			t.Errorf("POST %s with %d bytes: expected status %d, got %d", tt.path, len(tt.body), tt.expectedStatus, response.StatusCode)
+		}
+	}
+}
+
+func TestRouterServeHTTP_PathParamsInContext(t *testing.T) {
+	sub := NewRouter()
+	var params map[string]string
+	sub.Get("/members/{id}", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		params = http.PathParamsFrom(req.Context())
+	}))
+
+	r := NewRouter()
+	r.Mount("/teams/core", sub)
+
+	request := &http.Request{Method: "GET", Path: "/teams/core/members/7", Headers: http.Header{}}
+	r.ServeHTTP(request, &http.Response{Headers: http.Header{}})
+
+	if params["id"] != "7" {
+		t.Errorf("Expected id '7' in the context, got %v", params)
+	}
+}
+
+func TestRouterUse_RecoveryMiddleware(t *testing.T) {
+	var panics []any
+	r := NewRouter()
+	r.Use(middleware.RecoveryMiddleware(func(recovered any) { panics = append(panics, recovered) }))
+	r.Get("/boom", handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+		res.Headers.Set("Content-Type", "text/plain")
+		res.Body = "partial"
+		panic("boom")
+	}))
+
+	response := serve(r, "GET", "/boom")
+
+	if response.StatusCode != 500 {
+		t.Errorf("Expected status 500, got %d", response.StatusCode)
+	}
+	if response.Body != "" || response.Headers.Has("Content-Type") {
+		t.Errorf("Expected the partial response to be discarded, got %q %v", response.Body, response.Headers)
+	}
+	if response.Headers.Get("Connection") != "close" {
+		t.Errorf("Expected Connection: close, got '%s'", response.Headers.Get("Connection"))
+	}
+	if len(panics) != 1 || panics[0] != "boom" {
+		t.Errorf("Expected onPanic to be called with the panic value, got %v", panics)
+	}
+}
+
+func TestRouterHost_VirtualHosts(t *testing.T) {
+	bodyHandler := func(body string) handler.Handler {
+		return handler.HandlerFunc(func(req *http.Request, res *http.Response) {
+			res.StatusCode = 200
+			res.Body = body
+		})
+	}
+
+	r := NewRouter()
+	r.Use(tagMiddleware("global"))
+	r.Get("/", bodyHandler("default"))
+	r.Get("/about", bodyHandler("default about"))
+	r.Host("Example.com").Get("/about", bodyHandler("example about"))
+	r.Host("*.example.com").Get("/about", bodyHandler("wildcard about"))
+	r.Host("api.example.com").Get("/about", bodyHandler("api about"))
+
+	if r.Host("example.com") != r.Host("EXAMPLE.COM.") {
+		t.Error("Expected Host to return the same router for the same host")
+	}
+
+	tests := []struct {
+		host           string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"example.com", "/about", 200, "example about"},
+		{"EXAMPLE.com:4221", "/about", 200, "example about"},
+		{"www.example.com", "/about", 200, "wildcard about"},
+		{"api.example.com", "/about", 200, "api about"},
+		{"a.b.example.com", "/about", 200, "default about"},
+		{"other.org", "/about", 200, "default about"},
+		{"", "/about", 200, "default about"},
+		{"example.com", "/missing", 404, ""},
+	}
+
+	for _, tt := range tests {
+		request := &http.Request{Method: "GET", Path: tt.path, Headers: http.Header{}}
+		if tt.host != "" {
+			request.Headers.Set("Host", tt.host)
+		}
+		response := &http.Response{Headers: http.Header{}}
+
+		r.ServeHTTP(request, response)
+
+		if response.StatusCode != tt.expectedStatus || response.Body != tt.expectedBody {
+			t.Errorf("GET %s on host %q: expected %d %q, got %d %q", tt.path, tt.host, tt.expectedStatus, tt.expectedBody, response.StatusCode, response.Body)
+		}
+		if response.Headers.Get("X-Trace") != "global;" {
+			t.Errorf("GET %s on host %q: expected the router middlewares to run once, got %q", tt.path, tt.host, response.Headers.Get("X-Trace"))
+		}
+	}
+}
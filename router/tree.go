@@ -0,0 +1,197 @@
+package router
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// node is a node of the routing tree, one level per path segment. Lookups
+// walk the tree segment by segment, so their cost depends on the length of
+// the path and not on the number of registered routes.
+type node struct {
+	static   map[string]*node
+	params   []*node // parameter children, constrained ones first
+	wildcard *node
+
+	// name and regex describe the segment of parameter and wildcard nodes
+	name  string
+	regex *regexp.Regexp
+
+	// route is set when a pattern ends at this node
+	route *route
+}
+
+func newNode() *node {
+	return &node{static: make(map[string]*node)}
+}
+
+// clone returns a deep copy of the tree rooted at n, routes included.
+func (n *node) clone() *node {
+	c := &node{
+		static: make(map[string]*node, len(n.static)),
+		name:   n.name,
+		regex:  n.regex,
+	}
+	for seg, child := range n.static {
+		c.static[seg] = child.clone()
+	}
+	for _, child := range n.params {
+		c.params = append(c.params, child.clone())
+	}
+	if n.wildcard != nil {
+		c.wildcard = n.wildcard.clone()
+	}
+	if n.route != nil {
+		c.route = &route{pattern: n.route.pattern, handlers: maps.Clone(n.route.handlers)}
+	}
+	return c
+}
+
+// insert walks down the tree along the pattern segments, creating nodes as
+// needed, and returns the node where the pattern ends.
+func (n *node) insert(p *pattern) (*node, error) {
+	current := n
+	for _, seg := range p.segments {
+		switch seg.kind {
+		case staticSegment:
+			child, ok := current.static[seg.value]
+			if !ok {
+				child = newNode()
+				current.static[seg.value] = child
+			}
+			current = child
+
+		case paramSegment:
+			var child *node
+			for _, existing := range current.params {
+				if regexString(existing.regex) != regexString(seg.regex) {
+					continue
+				}
+				if existing.name != seg.value {
+					return nil, fmt.Errorf("pattern %q: parameter {%s} conflicts with {%s}", p.raw, seg.value, existing.name)
+				}
+				child = existing
+			}
+
+			if child == nil {
+				child = newNode()
+				child.name = seg.value
+				child.regex = seg.regex
+				current.params = append(current.params, child)
+				// Constrained parameters are tried before unconstrained ones
+				slices.SortStableFunc(current.params, func(a, b *node) int {
+					return boolToInt(a.regex == nil) - boolToInt(b.regex == nil)
+				})
+			}
+			current = child
+
+		case wildcardSegment:
+			if current.wildcard == nil {
+				current.wildcard = newNode()
+				current.wildcard.name = seg.value
+			} else if current.wildcard.name != seg.value {
+				return nil, fmt.Errorf("pattern %q: wildcard *%s conflicts with *%s", p.raw, seg.value, current.wildcard.name)
+			}
+			current = current.wildcard
+		}
+	}
+
+	return current, nil
+}
+
+type param struct {
+	name  string
+	value string
+}
+
+// lookupResult collects the outcome of a lookup: the matched route and its
+// handler, or the methods accepted by routes whose pattern matched the path.
+type lookupResult struct {
+	method  string
+	route   *route
+	params  []param
+	allowed []string
+}
+
+// try accepts rt if it serves the looked up method, otherwise records its
+// allowed methods for the 405 response.
+func (res *lookupResult) try(rt *route, params []param) bool {
+	if rt == nil {
+		return false
+	}
+	if rt.handlerFor(res.method) == nil {
+		res.allowed = append(res.allowed, rt.allowedMethods()...)
+		return false
+	}
+	res.route = rt
+	res.params = params
+	return true
+}
+
+// lookup finds the route for the remaining path segments. Candidates are tried
+// in a fixed order of precedence: static segments, then parameters, then
+// wildcards. Literal patterns other than "/" match every path below them, but
+// only when no longer pattern does.
+func (n *node) lookup(segments []string, params []param, res *lookupResult) bool {
+	if len(segments) == 0 {
+		if res.try(n.route, params) {
+			return true
+		}
+		if n.wildcard != nil {
+			return res.try(n.wildcard.route, append(params, param{n.wildcard.name, ""}))
+		}
+		return false
+	}
+
+	seg := segments[0]
+	if child, ok := n.static[seg]; ok && child.lookup(segments[1:], params, res) {
+		return true
+	}
+
+	if seg != "" {
+		for _, child := range n.params {
+			if child.regex != nil && !child.regex.MatchString(seg) {
+				continue
+			}
+			if child.lookup(segments[1:], append(params, param{child.name, seg}), res) {
+				return true
+			}
+		}
+	}
+
+	if n.wildcard != nil && res.try(n.wildcard.route, append(params, param{n.wildcard.name, strings.Join(segments, "/")})) {
+		return true
+	}
+
+	if n.route != nil && n.route.pattern.static && len(n.route.pattern.segments) > 0 {
+		return res.try(n.route, params)
+	}
+
+	return false
+}
+
+// splitPath splits a request path into segments, "/" has none.
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func regexString(regex *regexp.Regexp) string {
+	if regex == nil {
+		return ""
+	}
+	return regex.String()
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/config"
+)
+
+// dispatcher hands accepted connections to the goroutines serving them.
+// dispatch returns false when the server is at capacity and conn has not
+// been taken.
+type dispatcher interface {
+	dispatch(conn net.Conn) bool
+}
+
+func newDispatcher(cfg *config.Config, serve func(net.Conn), shutdown <-chan struct{}) dispatcher {
+	if cfg.Concurrency == config.ConcurrencyPerConnection {
+		return newPerConnection(cfg.MaxConnections, serve)
+	}
+	return newWorkerPool(cfg.MinWorkers, cfg.MaxWorkers, cfg.QueueSize, cfg.WorkerIdleTimeout, serve, shutdown)
+}
+
+// workerPool serves connections with between min and max workers. A worker
+// is started when none is idle, otherwise connections wait in the queue.
+type workerPool struct {
+	min, max    int
+	idleTimeout time.Duration
+	queue       chan net.Conn
+	serve       func(net.Conn)
+	shutdown    <-chan struct{}
+
+	mu      sync.Mutex
+	workers int
+	idle    int
+}
+
+func newWorkerPool(minWorkers, maxWorkers, queueSize int, idleTimeout time.Duration, serve func(net.Conn), shutdown <-chan struct{}) *workerPool {
+	p := &workerPool{
+		min:         minWorkers,
+		max:         max(maxWorkers, minWorkers, 1),
+		idleTimeout: idleTimeout,
+		queue:       make(chan net.Conn, queueSize),
+		serve:       serve,
+		shutdown:    shutdown,
+	}
+
+	p.workers = minWorkers
+	for range minWorkers {
+		go p.work(nil)
+	}
+	return p
+}
+
+func (p *workerPool) dispatch(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// The workers may be gone, nothing would take conn from the queue
+	select {
+	case <-p.shutdown:
+		return false
+	default:
+	}
+
+	if p.idle == 0 && p.workers < p.max {
+		p.workers++
+		go p.work(conn)
+		return true
+	}
+
+	// Queued under mu, so an idle worker timing out either sees conn before
+	// exiting or is no longer counted as idle above
+	select {
+	case p.queue <- conn:
+		return true
+	default:
+		return false
+	}
+}
+
+// work serves conn, if any, then the queued connections until the server
+// shuts down, once the queue is empty, or, for workers above min, nothing
+// comes for idleTimeout.
+func (p *workerPool) work(conn net.Conn) {
+	var idleTimer <-chan time.Time
+	for {
+		if conn != nil {
+			p.serve(conn)
+		}
+
+		p.mu.Lock()
+		p.idle++
+		p.mu.Unlock()
+
+		if p.idleTimeout > 0 {
+			idleTimer = time.After(p.idleTimeout)
+		}
+
+		select {
+		case conn = <-p.queue:
+			p.mu.Lock()
+			p.idle--
+			p.mu.Unlock()
+			continue
+		case <-idleTimer:
+		case <-p.shutdown:
+		}
+
+		p.mu.Lock()
+		p.idle--
+
+		// A connection may have been queued for this worker after the timer
+		// fired, or be left in the queue at shut down
+		select {
+		case conn = <-p.queue:
+			p.mu.Unlock()
+			continue
+		default:
+		}
+
+		select {
+		case <-p.shutdown:
+		default:
+			if p.workers <= p.min {
+				p.mu.Unlock()
+				conn = nil
+				continue
+			}
+		}
+		p.workers--
+		p.mu.Unlock()
+		return
+	}
+}
+
+// perConnection starts a goroutine for each connection, with at most
+// maxConnections running at once.
+type perConnection struct {
+	slots chan struct{}
+	serve func(net.Conn)
+}
+
+func newPerConnection(maxConnections int, serve func(net.Conn)) *perConnection {
+	p := &perConnection{serve: serve}
+	if maxConnections > 0 {
+		p.slots = make(chan struct{}, maxConnections)
+	}
+	return p
+}
+
+func (p *perConnection) dispatch(conn net.Conn) bool {
+	if p.slots == nil {
+		go p.serve(conn)
+		return true
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return false
+	}
+
+	go func() {
+		defer func() { <-p.slots }()
+		p.serve(conn)
+	}()
+	return true
+}
@@ -1,13 +1,19 @@
 package server
 
 import (
+	"bufio"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/signal"
+	"runtime/debug"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -22,16 +28,61 @@ import (
 
 type Server struct {
 	*config.Config
-	startTime                 time.Time
-	listener                  net.Listener
-	listenerTLS               net.Listener
-	router                    *router.Router
-	numberOfConnectionsWorker int
-	connectionsChan           chan net.Conn
-	connectionWaitGroup       sync.WaitGroup
-	openConnections           int64
-	totalRequests             int64
-	shutDownSignal            chan struct{}
+	startTime           time.Time
+	listener            net.Listener
+	listenerTLS         net.Listener
+	router              *router.Router
+	dispatcher          dispatcher
+	connectionWaitGroup sync.WaitGroup
+	openConnections     int64
+	totalRequests       int64
+	headerTimeouts      int64
+	bodyTimeouts        int64
+	writeTimeouts       int64
+	idleTimeouts        int64
+	panics              int64
+	shutDownSignal      chan struct{}
+
+	// shutdownDone is closed once ShutDown has completed, with its report
+	shutdownOnce   sync.Once
+	shutdownDone   chan struct{}
+	shutdownReport ShutdownReport
+
+	// conns tracks accepted connections so ShutDown can close the idle ones
+	// right away and force-close the others at the drain deadline
+	connsMu      sync.Mutex
+	conns        map[net.Conn]connState
+	shuttingDown bool
+
+	// rejections bounds the goroutines answering 503 to the connections over
+	// capacity, further ones are closed without a response
+	rejections chan struct{}
+
+	// baseContext is the parent of every request context, cancelled with
+	// http.ErrServerShutdown at the drain deadline
+	baseContext context.Context
+	cancelBase  context.CancelCauseFunc
+}
+
+type connState int
+
+const (
+	stateIdle   connState = iota // queued or waiting for the next request
+	stateActive                  // reading a request or serving it
+)
+
+// maxRejections is the number of connections over capacity answered at
+// once, and rejectWriteTimeout bounds the time to answer each of them.
+const (
+	maxRejections      = 16
+	rejectWriteTimeout = time.Second
+)
+
+// ShutdownReport tells how ShutDown closed the open connections.
+type ShutdownReport struct {
+	Idle    int // closed right away while waiting for a request
+	Drained int // closed after their in-flight request completed
+	Dropped int // force-closed at the drain deadline
 }
 
 func NewServer(cfg *config.Config) (*Server, error) {
@@ -39,30 +90,62 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		cfg = config.DefaultConfig()
 	}
 
+	// An invalid certificate fails here rather than at the first handshake
+	if err := cfg.LoadTLS(); err != nil {
+		return nil, err
+	}
+	logCertificates(cfg.Certificates())
+
 	router := router.NewRouter()
 
+	server := Server{
+		Config:         cfg,
+		router:         router,
+		shutDownSignal: make(chan struct{}),
+		shutdownDone:   make(chan struct{}),
+		conns:          make(map[net.Conn]connState),
+		rejections:     make(chan struct{}, maxRejections),
+	}
+	server.baseContext, server.cancelBase = context.WithCancelCause(context.Background())
+
+	// Recovery runs inside logging so a panic is logged as a 500
 	router.Use(
-		middleware.GzipMiddleware(),
+		middleware.RequestIDMiddleware(),
 		middleware.LoggingMiddleware(),
+		middleware.RecoveryMiddleware(server.countPanic),
 	)
 
-	server := Server{
-		Config:                    cfg,
-		router:                    router,
-		shutDownSignal:            make(chan struct{}),
-		connectionsChan:           make(chan net.Conn, 100), // Using buffered chan so if new connections queue up, Accept() continues accepting
-		numberOfConnectionsWorker: 10,
-	}
+	// The root answers an empty 200, whatever the method
+	router.Handle("/", handler.HandlerFunc(func(req *http.Request, resp *http.Response) {
+		resp.StatusCode = 200
+	}))
 
-	router.Handle("/files", handler.NewFileHandler(server.FileDir))
-	router.Handle("/echo", handler.NewEchoHandler())
-	router.Handle("/user-agent", handler.NewUserAgentHandler())
+	// Health checks are small and polled often, they skip compression
 	router.Handle("/health", handler.NewHealthHandler(&server))
 
+	compressed := router.Group("/", middleware.GzipMiddleware())
+	fileHandler := handler.NewFileHandler(server.FileDir)
+	compressed.Get("/files/{name}", handler.HandlerFunc(fileHandler.Read))
+	compressed.Post("/files/{name}", handler.HandlerFunc(fileHandler.Upload))
+	compressed.Handle("/echo/*message", handler.NewEchoHandler())
+	compressed.Handle("/user-agent", handler.NewUserAgentHandler())
+
 	return &server, nil
 }
 
-func (s *Server) ShutDown() {
+// ShutDown stops accepting connections, closes the idle ones and lets the
+// in-flight requests complete with "Connection: close" until
+// Config.DrainTimeout, after which the remaining connections are closed.
+// Later calls wait for the first one and return the same report.
+func (s *Server) ShutDown() ShutdownReport {
+	s.shutdownOnce.Do(func() {
+		s.shutdownReport = s.shutDown()
+		close(s.shutdownDone)
+	})
+	return s.shutdownReport
+}
+
+func (s *Server) shutDown() ShutdownReport {
 	// Signal ShutDown to worker and main routine
 	close(s.shutDownSignal)
 
@@ -74,9 +157,23 @@ func (s *Server) ShutDown() {
 		s.listenerTLS.Close()
 	}
 
-	// Wait for all connections to finish with timeout
+	// Idle connections would only be closed by their idle timeout
+	var report ShutdownReport
+	s.connsMu.Lock()
+	s.shuttingDown = true
+	for conn, state := range s.conns {
+		if state == stateIdle {
+			conn.Close()
+			delete(s.conns, conn)
+			report.Idle++
+		}
+	}
+	active := len(s.conns)
+	s.connsMu.Unlock()
+
+	// Wait for the in-flight requests until the drain deadline
 	done := make(chan struct{})
-	fmt.Println("opened connections " + strconv.FormatInt(s.openConnections, 10))
+	fmt.Println("opened connections " + strconv.Itoa(s.GetOpenConnections()))
 
 	go func() {
 		s.connectionWaitGroup.Wait()
@@ -85,49 +182,177 @@ func (s *Server) ShutDown() {
 
 	select {
 	case <-done:
-		fmt.Println("All connections closed gracefully")
-	case <-time.After(10 * time.Second):
-		fmt.Println("Graceful shutdown timeout - some connections may be force-closed")
+	case <-time.After(s.DrainTimeout):
+		s.cancelBase(http.ErrServerShutdown)
+		s.connsMu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+			report.Dropped++
+		}
+		s.connsMu.Unlock()
+	}
+	report.Drained = active - report.Dropped
+	s.cancelBase(http.ErrServerShutdown)
+
+	if report.Dropped == 0 {
+		fmt.Printf("All connections closed gracefully: %d idle, %d drained\n", report.Idle, report.Drained)
+	} else {
+		fmt.Printf("Drain deadline reached: %d idle, %d drained, %d dropped\n", report.Idle, report.Drained, report.Dropped)
 	}
+	return report
+}
+
+// trackConn records an accepted connection as idle and adds it to
+// connectionWaitGroup, which handleConnection or the caller rejecting it
+// must release. It returns false once ShutDown started.
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
 
+	if s.shuttingDown {
+		return false
+	}
+	// Added under connsMu, so before ShutDown waits for the group
+	s.connectionWaitGroup.Add(1)
+	s.conns[conn] = stateIdle
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.connsMu.Lock()
+	delete(s.conns, conn)
+	s.connsMu.Unlock()
+}
+
+// setConnState records the state of conn. It returns false if the
+// connection must not go on: closed by ShutDown while idle, or going idle
+// during shut down.
+func (s *Server) setConnState(conn net.Conn, state connState) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	if _, ok := s.conns[conn]; !ok || (s.shuttingDown && state == stateIdle) {
+		return false
+	}
+	s.conns[conn] = state
+	return true
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	return s.shuttingDown
 }
 
 func (s *Server) gracefulShutdownRoutine() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
-	fmt.Printf("Shutting down signal gracefully shutting down...\n")
+	defer signal.Stop(c)
 
-	s.ShutDown()
+	select {
+	case <-c:
+		fmt.Printf("Shutting down signal gracefully shutting down...\n")
+		s.ShutDown()
+	case <-s.shutDownSignal:
+	}
 }
 
-func (s *Server) Start() error {
+// Listen binds the HTTP listener and, when TLS is enabled, the TLS one.
+// Ports requested as "0" are replaced by the bound ones, so they can be read
+// once Listen returns. Start calls it unless it was called before.
+func (s *Server) Listen() error {
 	err := s.startTCPListener()
 	if err != nil {
 		return err
 	}
 
-	// Start TLS listener
-	tlsListener, err := tls.Listen("tcp", ":"+s.TLSPort, s.TlSConfig)
-	if err != nil {
-		return err
+	// TLS needs a certificate and a port, it is left out behind a
+	// terminating proxy
+	if s.TLSPort != "" {
+		if s.TlSConfig == nil {
+			fmt.Printf("TLS disabled on port %s: no certificate configured, see -tls-cert or -tls-dev\n", s.TLSPort)
+			return nil
+		}
+		if err := s.startTLSListener(); err != nil {
+			s.listener.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tlsReloadRoutine reloads the TLS certificates on SIGHUP and when their
+// files change, checked every TLSReloadInterval.
+func (s *Server) tlsReloadRoutine() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if s.TLSReloadInterval > 0 {
+		ticker := time.NewTicker(s.TLSReloadInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-hup:
+			s.reloadTLS("SIGHUP received")
+		case <-tick:
+			if s.TLSFilesChanged() {
+				s.reloadTLS("certificate files changed")
+			}
+		case <-s.shutDownSignal:
+			return
+		}
+	}
+}
+
+func (s *Server) reloadTLS(reason string) {
+	if err := s.ReloadTLS(); err != nil {
+		fmt.Printf("Failed to reload TLS certificates (%s), keeping the current ones: %v\n", reason, err)
+		return
+	}
+	fmt.Printf("Reloaded TLS certificates (%s)\n", reason)
+	logCertificates(s.Certificates())
+}
+
+func logCertificates(certs []*x509.Certificate) {
+	for _, cert := range certs {
+		fmt.Printf("TLS certificate %s: serial %s, expires %s\n", cert.Subject, cert.SerialNumber.Text(16), cert.NotAfter.Format(time.RFC3339))
+	}
+}
+
+// Start serves the connections until ShutDown, on SIGINT or SIGTERM or when
+// called directly, has drained them.
+func (s *Server) Start() error {
+	if s.listener == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
 	}
-	s.listenerTLS = tlsListener
 
 	// Start routine that trigger when shutting down
 	go s.gracefulShutdownRoutine()
 
-	// Start routine that handle connection creation
-	for i := range s.numberOfConnectionsWorker {
-		go s.startConnectionHandler(i)
+	if s.listenerTLS != nil {
+		go s.tlsReloadRoutine()
 	}
 
+	// Start the goroutines serving connections
+	s.dispatcher = newDispatcher(s.Config, s.handleConnection, s.shutDownSignal)
+
 	s.startTime = time.Now()
 
-	go s.listenForConnections(s.listenerTLS)
+	if s.listenerTLS != nil {
+		go s.listenForConnections(s.listenerTLS)
+	}
 	go s.listenForConnections(s.listener)
 
-	<-s.shutDownSignal
+	// Returning earlier would let main exit in the middle of the drain
+	<-s.shutdownDone
 	return nil
 }
 
@@ -146,19 +371,31 @@ func (s *Server) listenForConnections(listener net.Listener) {
 			}
 		}
 
-		select {
-		case s.connectionsChan <- conn:
-			// Connection sent to worker pool
-		default:
-			// Channel is full, reject the connection
-			fmt.Println("Worker pool full, rejecting connection")
+		if !s.trackConn(conn) {
 			conn.Close()
+			return
+		}
+		if !s.dispatcher.dispatch(conn) {
+			// At capacity, tell the client instead of dropping it, unless
+			// too many are being told already
+			fmt.Println("Server at capacity, rejecting connection")
+			s.untrackConn(conn)
+			s.connectionWaitGroup.Done()
+			select {
+			case s.rejections <- struct{}{}:
+				go func() {
+					defer func() { <-s.rejections }()
+					s.rejectConnection(conn, 503, rejectWriteTimeout)
+				}()
+			default:
+				conn.Close()
+			}
 		}
 	}
 }
 
 func (s *Server) startTCPListener() error {
-	listener, err := net.Listen("tcp", "0.0.0.0:"+s.Port)
+	listener, err := net.Listen("tcp", net.JoinHostPort(s.Host, s.Port))
 	if err != nil {
 		fmt.Println("Failed to bind to port ", s.Port)
 		return err
@@ -174,63 +411,236 @@ func (s *Server) startTCPListener() error {
 	return nil
 }
 
-func (s *Server) startConnectionHandler(workerID int) {
-	for {
-		select {
-		case conn := <-s.connectionsChan:
-			s.handleConnection(conn)
-		case <-s.shutDownSignal:
-			return
-		}
+func (s *Server) startTLSListener() error {
+	host := s.TLSHost
+	if host == "" {
+		host = s.Host
+	}
+
+	listener, err := tls.Listen("tcp", net.JoinHostPort(host, s.TLSPort), s.TlSConfig)
+	if err != nil {
+		fmt.Println("Failed to bind to TLS port ", s.TLSPort)
+		return err
 	}
+	s.listenerTLS = listener
+
+	if s.TLSPort == "0" {
+		addr := listener.Addr().(*net.TCPAddr)
+		s.TLSPort = fmt.Sprintf("%d", addr.Port)
+	}
+
+	return nil
 }
 
 func (s *Server) Stop() {
 	s.listener.Close()
 }
 
+// handleConnection serves the requests of a connection added by trackConn.
 func (s *Server) handleConnection(conn net.Conn) {
-	s.connectionWaitGroup.Add(1)
 	atomic.AddInt64(&s.openConnections, 1)
 	defer func() {
+		// A panic outside the handlers must not kill the worker either
+		if recovered := recover(); recovered != nil {
+			fmt.Printf("Panic serving connection %s: %v\n%s", conn.RemoteAddr(), recovered, debug.Stack())
+			s.countPanic(recovered)
+		}
 		conn.Close()
+		s.untrackConn(conn)
 		atomic.AddInt64(&s.openConnections, -1)
 		s.connectionWaitGroup.Done()
 	}()
 
-	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
-	for {
-		request, err := http.ParseRequest(conn)
+	// The reader lives as long as the connection so bytes read past the end
+	// of a request, e.g. pipelined requests, are parsed on the next iteration
+	reader := bufio.NewReaderSize(conn, s.BufferSize)
+	limits := http.Limits{
+		HeaderTimeout:      s.ReadHeaderTimeout,
+		BodyTimeout:        s.ReadBodyTimeout,
+		MaxRequestLineSize: s.MaxRequestLineSize,
+		MaxHeaderBytes:     s.MaxHeaderBytes,
+		MaxHeaderCount:     s.MaxHeaderCount,
+		MaxBodySize:        s.MaxBodySize,
+	}
+
+	// The client certificate, if any, is known once the handshake is done
+	// while reading the first request
+	var clientCert *http.ClientCert
+
+	for first := true; ; first = false {
+		// Wait for the first byte of the request, a client sending nothing
+		// is closed without response
+		waitTimeout, waitTimeouts := s.IdleTimeout, &s.idleTimeouts
+		if first {
+			waitTimeout, waitTimeouts = s.ReadHeaderTimeout, &s.headerTimeouts
+		}
+		if waitTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(waitTimeout))
+		}
+		if !s.setConnState(conn, stateIdle) {
+			return
+		}
+		if _, err := reader.Peek(1); err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				atomic.AddInt64(waitTimeouts, 1)
+			}
+			return // Client closed the connection, timeout or shut down
+		}
+		if !s.setConnState(conn, stateActive) {
+			return // Closed by ShutDown while the request arrived
+		}
+		conn.SetReadDeadline(time.Time{})
+
+		request, err := http.ReadRequest(reader, conn, limits)
 		if err != nil {
 			if err == io.EOF {
 				return // Client closed the connection
 			}
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				return // Timeout
+			if errors.Is(err, http.ErrHeaderTimeout) {
+				atomic.AddInt64(&s.headerTimeouts, 1)
+			}
+			if errors.Is(err, http.ErrBodyTimeout) {
+				atomic.AddInt64(&s.bodyTimeouts, 1)
 			}
 			fmt.Println("Error parsing request:", err)
+
+			// A request the client started but did not complete, or
+			// malformed, is answered before closing the connection
+			s.rejectConnection(conn, http.ErrorStatus(err), s.WriteTimeout)
 			return
 		}
 
 		// Increment total requests counter
 		atomic.AddInt64(&s.totalRequests, 1)
 
-		// Clear read deadline during processing
-		conn.SetDeadline(time.Time{})
+		if tlsConn, ok := conn.(*tls.Conn); ok && first {
+			state := tlsConn.ConnectionState()
+			clientCert = http.ClientCertFromState(&state)
+		}
+		request.ClientCert = clientCert
+
+		// A body read later by the handler sets its own read deadline, the
+		// whole response has to be written within the write timeout
+		conn.SetReadDeadline(time.Time{})
+		if s.WriteTimeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+		}
+
+		parent, cancelTimeout := s.baseContext, context.CancelFunc(func() {})
+		if s.RequestTimeout > 0 {
+			parent, cancelTimeout = context.WithTimeout(parent, s.RequestTimeout)
+		}
+		if clientCert != nil {
+			parent = http.WithPrincipal(parent, clientCert.Subject)
+		}
+		ctx, cancel := context.WithCancelCause(parent)
+		request.SetContext(ctx)
+
+		// A body still to be read is read by the handler, otherwise the
+		// connection is watched for the client going away
+		stopWatch := func() {}
+		if request.BodyConsumed() {
+			stopWatch = watchDisconnect(conn, reader, cancel)
+		}
 
 		response := http.NewResponse(request)
-		s.router.ServeHTTP(request, response)
-		response.SendToClient(request)
+		s.serveRequest(request, response)
+		stopWatch()
+		cancel(nil)
+		cancelTimeout()
+
+		// A body the handler did not read, e.g. an upload rejected before
+		// 100 Continue, is still on the wire, the connection cannot be reused
+		keepAlive := request.BodyConsumed() &&
+			!strings.EqualFold(request.Headers.Get("Connection"), "close") &&
+			!strings.EqualFold(response.Headers.Get("Connection"), "close") &&
+			!s.isShuttingDown()
+		if !keepAlive && !response.Committed() {
+			response.Headers.Set("Connection", "close")
+		}
+		if err := response.SendToClient(request); err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				atomic.AddInt64(&s.writeTimeouts, 1)
+			}
+			return
+		}
 
-		if request.Headers["Connection"] == "close" {
+		if !keepAlive {
 			break
 		}
+	}
+}
+
+// serveRequest runs the router. A panic in the middlewares running before
+// the router recovery one is answered with a 500 the same way.
+func (s *Server) serveRequest(request *http.Request, response *http.Response) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			middleware.RecoverResponse(request, response, recovered)
+			s.countPanic(recovered)
+		}
+	}()
+	s.router.ServeHTTP(request, response)
+}
+
+func (s *Server) countPanic(recovered any) {
+	atomic.AddInt64(&s.panics, 1)
+}
+
+// watchDisconnect cancels the request context with
+// http.ErrClientDisconnected if the client closes the connection while the
+// request is served. The returned function stops watching, it must be called
+// before reading from reader again. Bytes of a pipelined request stay
+// buffered in reader.
+func watchDisconnect(conn net.Conn, reader *bufio.Reader, cancel context.CancelCauseFunc) func() {
+	done := make(chan struct{})
+	var stopping atomic.Bool
+
+	go func() {
+		defer close(done)
+		if _, err := reader.Peek(1); err != nil && !stopping.Load() {
+			cancel(http.ErrClientDisconnected)
+		}
+	}()
+
+	return func() {
+		// A deadline in the past interrupts the pending read
+		stopping.Store(true)
+		conn.SetReadDeadline(time.Unix(1, 0))
+		<-done
+		conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// rejectConnection answers statusCode to a request that could not be read
+// or served, within writeTimeout if positive, then closes conn.
+func (s *Server) rejectConnection(conn net.Conn, statusCode int, writeTimeout time.Duration) {
+	defer conn.Close()
 
-		// Set keep-alive timeout for next request
-		conn.SetReadDeadline(time.Now().Add(time.Second * 60))
+	if writeTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+
+	request := &http.Request{Connection: conn, Headers: http.Header{}}
+	response := http.NewResponse(request)
+	response.StatusCode = statusCode
+	response.Headers.Set("Connection", "close")
+	response.SendToClient(request)
+
+	// Closing with unread data resets the connection, which can discard the
+	// response before the client reads it, so drain a little first
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		io.Copy(io.Discard, io.LimitReader(conn, 256*1024))
 	}
 }
 
+// Router returns the router serving the requests, to register more routes.
+func (s *Server) Router() *router.Router {
+	return s.router
+}
+
 func (s *Server) GetOpenConnections() int {
 	return int(atomic.LoadInt64(&s.openConnections))
 }
@@ -239,6 +649,31 @@ func (s *Server) GetTotalRequests() int {
 	return int(atomic.LoadInt64(&s.totalRequests))
 }
 
+func (s *Server) GetTimeouts() handler.TimeoutCounts {
+	return handler.TimeoutCounts{
+		Header: int(atomic.LoadInt64(&s.headerTimeouts)),
+		Body:   int(atomic.LoadInt64(&s.bodyTimeouts)),
+		Write:  int(atomic.LoadInt64(&s.writeTimeouts)),
+		Idle:   int(atomic.LoadInt64(&s.idleTimeouts)),
+	}
+}
+
+func (s *Server) GetPanics() int {
+	return int(atomic.LoadInt64(&s.panics))
+}
+
+func (s *Server) GetCertificates() []handler.CertificateInfo {
+	var infos []handler.CertificateInfo
+	for _, cert := range s.Certificates() {
+		infos = append(infos, handler.CertificateInfo{
+			Names:    cert.DNSNames,
+			Serial:   cert.SerialNumber.Text(16),
+			NotAfter: cert.NotAfter.Format(time.RFC3339),
+		})
+	}
+	return infos
+}
+
 func (s *Server) ServerStartTime() time.Time {
 	return s.startTime
 }